@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/denisb0/import_embeddings/models"
+)
+
+type dimensionMismatch struct {
+	Table     string
+	ID        uuid.UUID
+	Type      string
+	Dimension int
+}
+
+func runAudit(ctx context.Context, common commonOptions, args []string) error {
+	flags := flag.NewFlagSet("audit", flag.ExitOnError)
+	dim := flags.Int("dim", embeddingSize, "expected number of values in every stored embedding")
+	embeddingType := flags.String("type", "", "audit only embeddings of this type")
+	limit := flags.Int("limit", 0, "report at most this many rows, 0 reports all of them")
+	typeToTable := flags.String("type-to-table", "", "comma separated type=table pairs the import routed to other tables, as given to import, those tables are audited too")
+	_ = flags.Parse(args)
+
+	routes, err := parseTypeRoutes(*typeToTable, "")
+	if err != nil {
+		return err
+	}
+
+	db, err := getDBConn(common)
+	if err != nil {
+		return err
+	}
+	db = db.WithContext(ctx)
+
+	var mismatches []dimensionMismatch
+	for _, table := range routeTables(models.Embeddings{}.TableName(), routes, *embeddingType) {
+		dimExpr, err := dimensionExpr(db, table)
+		if err != nil {
+			return fmt.Errorf("%s: %w", table, err)
+		}
+
+		query := db.Table(table).
+			Select("id, type, "+dimExpr+" AS dimension").
+			Where(dimExpr+" <> ?", *dim).
+			Order("type, id")
+		if *embeddingType != "" {
+			query = query.Where("type = ?", *embeddingType)
+		}
+		if *limit > 0 {
+			query = query.Limit(*limit - len(mismatches))
+		}
+
+		var found []dimensionMismatch
+		if err := query.Scan(&found).Error; err != nil {
+			return fmt.Errorf("%s: audit query error: %w", table, err)
+		}
+		for i := range found {
+			found[i].Table = table
+		}
+		mismatches = append(mismatches, found...)
+
+		if *limit > 0 && len(mismatches) >= *limit {
+			break
+		}
+	}
+
+	if len(mismatches) == 0 {
+		fmt.Println("ok, every embedding has dimension", *dim)
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TABLE\tID\tTYPE\tDIMENSION")
+	for _, m := range mismatches {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\n", m.Table, m.ID, m.Type, m.Dimension)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	return fmt.Errorf("%d embeddings with dimension other than %d", len(mismatches), *dim)
+}
+
+// dimensionExpr returns the SQL expression for the number of values in the embedding column of table, which is
+// either a real[], a pgvector vector or bytea packed 4 bytes per value. Empty and NULL arrays count as dimension 0.
+func dimensionExpr(db *gorm.DB, table string) (string, error) {
+	udtName, err := embeddingColumnType(db, table)
+	if err != nil {
+		return "", err
+	}
+
+	switch udtName {
+	case "_float4", "_float8":
+		return "coalesce(array_length(embedding, 1), 0)", nil
+	case "vector":
+		return "coalesce(vector_dims(embedding), 0)", nil
+	case "bytea":
+		return "coalesce(octet_length(embedding) / 4, 0)", nil
+	default:
+		return "", fmt.Errorf("unsupported embedding column type %s", udtName)
+	}
+}
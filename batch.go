@@ -0,0 +1,157 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/denisb0/import_embeddings/models"
+)
+
+// pendingEmbedding is a buffered embedding with its input position, needed to report errors and resume
+type pendingEmbedding struct {
+	Embedding models.Embeddings
+	Line      int
+	Read      int64    // records read up to and including this one
+	Fields    []string // input record, written to the dead letter file if the batch fails
+	Route     tableRoute
+}
+
+// memoryBytes approximates the memory a buffered embedding holds, mostly its vector, content and input fields
+func (p pendingEmbedding) memoryBytes() int64 {
+	const overhead = 256 // struct, slice and string headers
+	n := overhead + 4*len(p.Embedding.Embedding) + len(p.Embedding.Type)
+	if p.Embedding.Content != nil {
+		n += len(*p.Embedding.Content)
+	}
+	for _, field := range p.Fields {
+		n += 16 + len(field)
+	}
+
+	return int64(n)
+}
+
+// batchWriter buffers embeddings and hands them to write together once size of them are pending. With an interval
+// a background goroutine also writes a partial batch every interval, so a slow input doesn't keep records
+// uncommitted for long. With maxBytes a batch is also written before its estimated memory would pass that limit.
+// write is never called concurrently.
+type batchWriter struct {
+	mu        sync.Mutex
+	write     func(batch []pendingEmbedding) error
+	size      int
+	maxBytes  int64 // 0 is unlimited
+	bytes     int64 // estimated memory of pending
+	pending   []pendingEmbedding
+	unwritten int64 // Read of the first record of a batch whose write failed, 0 if none
+	err       error
+	stop      chan struct{}
+	done      chan struct{}
+}
+
+func newBatchWriter(size int, interval time.Duration, maxBytes int64, write func(batch []pendingEmbedding) error) *batchWriter {
+	b := &batchWriter{write: write, size: size, maxBytes: maxBytes, pending: make([]pendingEmbedding, 0, size)}
+	if interval > 0 {
+		b.stop, b.done = make(chan struct{}), make(chan struct{})
+		go b.flushEvery(interval)
+	}
+
+	return b
+}
+
+func (b *batchWriter) flushEvery(interval time.Duration) {
+	defer close(b.done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-b.stop:
+			return
+		case <-ticker.C:
+			b.mu.Lock()
+			b.flush()
+			b.mu.Unlock()
+		}
+	}
+}
+
+// Add buffers the embedding and writes the batch once it is full. After a failed write it returns the error
+// and buffers nothing more.
+func (b *batchWriter) Add(p pendingEmbedding) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.err != nil {
+		return b.err
+	}
+
+	bytes := p.memoryBytes()
+	if b.maxBytes > 0 && len(b.pending) > 0 && b.bytes+bytes > b.maxBytes {
+		log.Printf("memory guard: writing %d buffered records (%s) before -max-memory %s is reached", len(b.pending), formatBytes(b.bytes), formatBytes(b.maxBytes))
+		b.flush()
+		if b.err != nil {
+			return b.err
+		}
+	}
+
+	b.pending = append(b.pending, p)
+	b.bytes += bytes
+	if len(b.pending) >= b.size {
+		b.flush()
+	}
+
+	return b.err
+}
+
+// flush writes the pending batch, the caller holds mu
+func (b *batchWriter) flush() {
+	if len(b.pending) == 0 || b.err != nil {
+		return
+	}
+
+	if err := b.write(b.pending); err != nil {
+		b.err = err
+		b.unwritten = firstRead(b.pending)
+	}
+	b.pending = b.pending[:0]
+	b.bytes = 0
+}
+
+// Close stops the background writes and writes what is still pending, it can be called more than once
+func (b *batchWriter) Close() error {
+	if b.stop != nil {
+		close(b.stop)
+		<-b.done
+		b.stop = nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.flush()
+	return b.err
+}
+
+// Unwritten returns Read of the first record that is pending or in a failed batch, 0 if everything was written
+func (b *batchWriter) Unwritten() int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.unwritten > 0 {
+		return b.unwritten
+	}
+	return firstRead(b.pending)
+}
+
+// firstRead returns the lowest Read of the batch, 0 if it is empty. Workers can add records out of input order.
+func firstRead(pending []pendingEmbedding) int64 {
+	var first int64
+	for _, p := range pending {
+		if first == 0 || p.Read < first {
+			first = p.Read
+		}
+	}
+
+	return first
+}
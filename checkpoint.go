@@ -0,0 +1,94 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+
+	"github.com/denisb0/import_embeddings/models"
+)
+
+// readCheckpoint returns the number of records handled by a previous run, ok is false if there is no checkpoint yet
+func readCheckpoint(path string) (position int64, ok bool, err error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return 0, false, nil
+		}
+		return 0, false, err
+	}
+
+	position, err = strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return 0, false, err
+	}
+
+	return position, true, nil
+}
+
+// writeCheckpoint atomically replaces the checkpoint with the number of handled records
+func writeCheckpoint(path string, position int64) error {
+	return writeFileAtomic(path, []byte(strconv.FormatInt(position, 10)+"\n"))
+}
+
+// exportPosition is the last embedding id an export wrote and the table it was read from
+type exportPosition struct {
+	Table string
+	ID    uuid.UUID
+}
+
+// readExportCheckpoint returns the position written by a previous export, ok is false if there is no checkpoint
+// yet. A checkpoint holding only the id, from before exports read several tables, is in the default table.
+func readExportCheckpoint(path string) (pos exportPosition, ok bool, err error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return exportPosition{}, false, nil
+		}
+		return exportPosition{}, false, err
+	}
+
+	pos.Table = models.Embeddings{}.TableName()
+	text := strings.TrimSpace(string(data))
+	if table, id, ok := strings.Cut(text, " "); ok {
+		pos.Table, text = table, id
+	}
+
+	pos.ID, err = uuid.Parse(text)
+	if err != nil {
+		return exportPosition{}, false, err
+	}
+
+	return pos, true, nil
+}
+
+// writeExportCheckpoint atomically replaces the checkpoint with the position of the last exported embedding
+func writeExportCheckpoint(path string, pos exportPosition) error {
+	return writeFileAtomic(path, []byte(pos.Table+" "+pos.ID.String()+"\n"))
+}
+
+// writeFileAtomic writes data to a temporary file next to path and renames it over path, so readers never see
+// a partial file
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,760 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/caarlos0/env/v9"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/joho/godotenv"
+	"github.com/lib/pq"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/logger"
+
+	"github.com/denisb0/import_embeddings/models"
+)
+
+func getDBConn(common commonOptions) (*gorm.DB, error) {
+	if err := godotenv.Load(common.EnvFile); err != nil {
+		return nil, err
+	}
+
+	return openDB("DB_", common)
+}
+
+// getSecondaryDBConn opens the optional mirror database configured with SECONDARY_DB_* variables,
+// returns nil if it is not configured. The dotenv file must already be loaded by getDBConn.
+func getSecondaryDBConn(common commonOptions) (*gorm.DB, error) {
+	if os.Getenv("SECONDARY_DB_HOST") == "" {
+		return nil, nil
+	}
+
+	return openDB("SECONDARY_DB_", common)
+}
+
+// poolerExecModes maps supported pooler modes to the pgx query exec mode used for them:
+//   - "" or "session": pgx default, prepared statements are cached per connection
+//   - "transaction": no named prepared statements, for PgBouncer transaction pooling
+//   - "simple": simple query protocol, for poolers without extended protocol support
+var poolerExecModes = map[string]string{
+	"":            "",
+	"session":     "",
+	"transaction": "exec",
+	"simple":      "simple_protocol",
+}
+
+// dbLogLevels maps supported -db-log-level values to gorm logger levels, info logs every SQL statement
+var dbLogLevels = map[string]logger.LogLevel{
+	"silent": logger.Silent,
+	"error":  logger.Error,
+	"warn":   logger.Warn,
+	"info":   logger.Info,
+}
+
+// openDB connects using <envPrefix>* variables, the -pooler mode overrides <envPrefix>POOLER_MODE if set. A database
+// that doesn't accept connections yet is tried again up to -connect-retries times with a linear backoff.
+func openDB(envPrefix string, common commonOptions) (*gorm.DB, error) {
+	type Config struct {
+		DBHost       string `env:"HOST,required"`
+		DBPort       string `env:"PORT" envDefault:"5432"`
+		DBUser       string `env:"USER,required"`
+		DBPassword   string `env:"PASSWORD,required"`
+		DBName       string `env:"NAME,required"`
+		DBPoolerMode string `env:"POOLER_MODE"`
+		DBMaxConns   int    `env:"MAX_OPEN_CONNS"` // 0 is unlimited
+	}
+
+	var cfg Config
+	if err := env.ParseWithOptions(&cfg, env.Options{Prefix: envPrefix}); err != nil {
+		return nil, err
+	}
+
+	if common.PoolerMode != "" {
+		cfg.DBPoolerMode = common.PoolerMode
+	}
+
+	execMode, ok := poolerExecModes[cfg.DBPoolerMode]
+	if !ok {
+		return nil, fmt.Errorf("unsupported pooler mode: %s", cfg.DBPoolerMode)
+	}
+
+	level, ok := dbLogLevels[common.DBLogLevel]
+	if !ok {
+		return nil, fmt.Errorf("unsupported db log level: %s", common.DBLogLevel)
+	}
+
+	dsn := fmt.Sprintf("host=%s user=%s password=%s dbname=%s port=%s application_name=%s",
+		cfg.DBHost, cfg.DBUser, cfg.DBPassword, cfg.DBName, cfg.DBPort, "yggdrasil")
+	if execMode != "" {
+		dsn += " default_query_exec_mode=" + execMode
+	}
+
+	var db *gorm.DB
+	var err error
+	for attempt := 0; ; attempt++ {
+		// gorm pings the database, so a server that is still starting fails here
+		db, err = gorm.Open(postgres.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(level)})
+		if err == nil || attempt >= common.ConnectRetries {
+			break
+		}
+
+		delay := time.Duration(attempt+1) * common.ConnectRetryDelay
+		log.Printf("%sHOST %s: connect error, retry %d of %d in %s: %v", envPrefix, cfg.DBHost, attempt+1, common.ConnectRetries, delay, err)
+		time.Sleep(delay)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.DBMaxConns > 0 {
+		sqlDB, err := db.DB()
+		if err != nil {
+			return nil, err
+		}
+		sqlDB.SetMaxOpenConns(cfg.DBMaxConns)
+	}
+
+	return db, nil
+}
+
+// maxOpenConns returns the connection limit of the pool, 0 is unlimited
+func maxOpenConns(db *gorm.DB) (int, error) {
+	sqlDB, err := db.DB()
+	if err != nil {
+		return 0, err
+	}
+
+	return sqlDB.Stats().MaxOpenConnections, nil
+}
+
+// latestCreatedAt returns the newest created_at stored for the type, zero time if there are no rows
+func latestCreatedAt(db *gorm.DB, embeddingType string) (time.Time, error) {
+	var latest sql.NullTime
+	err := db.Model(&models.Embeddings{}).Select("max(created_at)").Where("type = ?", embeddingType).Row().Scan(&latest)
+	return latest.Time, err
+}
+
+// postgres error codes handled explicitly
+const (
+	pgInsufficientPrivilege = "42501"
+	pgUndefinedFile         = "58P01"
+	pgUniqueViolation       = "23505"
+)
+
+// migrate creates or updates the embeddings table with the embedding column of columnType. With pgvector it first
+// makes sure the vector extension is installed, so the vector column type is available on a fresh database.
+func migrate(db *gorm.DB, pgvector bool, columnType string) error {
+	if pgvector {
+		if err := db.Exec("CREATE EXTENSION IF NOT EXISTS vector").Error; err != nil {
+			var pgErr *pgconn.PgError
+			if errors.As(err, &pgErr) {
+				switch pgErr.Code {
+				case pgInsufficientPrivilege:
+					return fmt.Errorf("permission denied to create extension vector, grant CREATE on the database or ask a superuser to install it: %w", err)
+				case pgUndefinedFile:
+					return fmt.Errorf("extension vector is not available on the database server, install pgvector first: %w", err)
+				}
+			}
+			return fmt.Errorf("create extension vector error: %w", err)
+		}
+	}
+
+	if columnType == models.ColumnTypeBytea {
+		return db.AutoMigrate(&models.EmbeddingsBytea{})
+	}
+	return db.AutoMigrate(&models.Embeddings{})
+}
+
+// urlSources maps supported url sources to the SQL expression holding a content entry's URL:
+//   - "json": url key of the entry_data JSON, the original schema
+//   - "column": url column of content_entry, the newer schema
+var urlSources = map[string]string{
+	"json":   "entry_data->>'url'",
+	"column": "url",
+}
+
+// findEntryByURL looks up the entry by exact URL, urlColumn is one of urlSources
+func findEntryByURL(db *gorm.DB, urlColumn, url string) (uuid.UUID, error) {
+	var entry models.ContentEntry
+	if err := entryByURLQuery(db, urlColumn, url).Take(&entry).Error; err != nil {
+		return uuid.UUID{}, err
+	}
+
+	return entry.ID, nil
+}
+
+func entryByURLQuery(db *gorm.DB, urlColumn, url string) *gorm.DB {
+	return db.Model(&models.ContentEntry{}).Select("id").Where(urlColumn+" = ?", url)
+}
+
+// explainFindEntryByURL runs the findEntryByURL query for url under EXPLAIN ANALYZE and returns the plan lines
+func explainFindEntryByURL(db *gorm.DB, urlColumn, url string) ([]string, error) {
+	// a dry run session builds the exact statement findEntryByURL sends without running it
+	stmt := entryByURLQuery(db.Session(&gorm.Session{DryRun: true}), urlColumn, url).Take(&models.ContentEntry{}).Statement
+
+	var plan []string
+	err := db.Raw("EXPLAIN (ANALYZE) "+stmt.SQL.String(), stmt.Vars...).Scan(&plan).Error
+	return plan, err
+}
+
+// findEntryByNormalizedURL matches entries whose URL equals rawURL once both are normalized. Candidates are
+// narrowed with a case-insensitive prefix match on scheme, host and path, which is much slower than
+// findEntryByURL, so it is meant as a fallback when the exact lookup finds nothing.
+func findEntryByNormalizedURL(db *gorm.DB, urlColumn string, normalizer *urlNormalizer, rawURL string) (uuid.UUID, error) {
+	target := normalizer.Normalize(rawURL)
+	u, err := url.Parse(target)
+	if err != nil {
+		return uuid.UUID{}, gorm.ErrRecordNotFound
+	}
+
+	prefix := u.Scheme + "://" + u.Host + u.EscapedPath()
+	likeEscaper := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+	var entries []struct {
+		ID  uuid.UUID
+		URL string
+	}
+	if err := db.Model(&models.ContentEntry{}).Select("id, "+urlColumn+" AS url").
+		Where(urlColumn+" ILIKE ?", likeEscaper.Replace(prefix)+"%").
+		Scan(&entries).Error; err != nil {
+		return uuid.UUID{}, err
+	}
+
+	for _, entry := range entries {
+		if normalizer.Normalize(entry.URL) == target {
+			return entry.ID, nil
+		}
+	}
+
+	return uuid.UUID{}, gorm.ErrRecordNotFound
+}
+
+// insertConflict makes inserts ignore embeddings already stored. With a unique index on the natural key
+// (entry_id, type) that is the target, so a record imported twice is stored once. Otherwise only ids already
+// stored are ignored, and a table partitioned by type can't have a unique index on id alone, so there the target
+// is left out and any conflict is ignored (ON CONFLICT on partitioned tables needs postgres 11 or later).
+func insertConflict(partitioned, naturalKey bool) clause.OnConflict {
+	if naturalKey {
+		return clause.OnConflict{
+			Columns:   []clause.Column{{Name: "entry_id"}, {Name: "type"}},
+			DoNothing: true,
+		}
+	}
+	if partitioned {
+		return clause.OnConflict{DoNothing: true}
+	}
+
+	return clause.OnConflict{
+		Columns:   []clause.Column{{Name: "id"}},
+		DoNothing: true,
+	}
+}
+
+// addEmbedding inserts the embedding, conflict comes from insertConflict. It reports whether the row was inserted,
+// it isn't when conflict ignored it.
+func addEmbedding(db *gorm.DB, embedding models.Embeddings, conflict clause.OnConflict) (bool, error) {
+	res := db.Clauses(conflict).Create(embedding)
+	return res.RowsAffected > 0, res.Error
+}
+
+// addOrphanEmbedding inserts the embedding with a NULL entry_id, for records whose content entry wasn't found.
+// Columns the session already omits stay omitted. It reports whether the row was inserted like addEmbedding.
+func addOrphanEmbedding(db *gorm.DB, embedding models.Embeddings, conflict clause.OnConflict) (bool, error) {
+	omits := append([]string{"entry_id"}, db.Statement.Omits...)
+	res := db.Omit(omits...).Clauses(conflict).Create(embedding)
+	return res.RowsAffected > 0, res.Error
+}
+
+// addEmbeddings inserts embeddings with a single statement and returns the ids of the rows inserted, those conflict
+// ignored are left out
+func addEmbeddings(db *gorm.DB, embeddings []models.Embeddings, conflict clause.OnConflict) ([]uuid.UUID, error) {
+	// a dry run session builds the insert without a transaction, gorm would scan RETURNING rows into embeddings by
+	// position and so misplace ids once conflict ignores a row
+	stmt := db.Session(&gorm.Session{DryRun: true, SkipDefaultTransaction: true}).Clauses(conflict).Create(&embeddings).Statement
+	if stmt.Error != nil {
+		return nil, stmt.Error
+	}
+
+	var inserted []uuid.UUID
+	err := db.Raw(stmt.SQL.String()+" RETURNING id", stmt.Vars...).Scan(&inserted).Error
+	return inserted, err
+}
+
+// replaceEmbeddingIfNewer upserts on the natural key (entry_id, type), an existing row is overwritten only when
+// the incoming created_at is newer. It reports whether a row was written.
+func replaceEmbeddingIfNewer(db *gorm.DB, embedding models.Embeddings) (bool, error) {
+	res := db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "entry_id"}, {Name: "type"}},
+		Where:     clause.Where{Exprs: []clause.Expression{clause.Expr{SQL: "excluded.created_at > ?.created_at", Vars: []interface{}{clause.Table{Name: clause.CurrentTable}}}}},
+		DoUpdates: clause.AssignmentColumns(replacedColumns(embedding)),
+	}).Create(embedding)
+	return res.RowsAffected > 0, res.Error
+}
+
+// replacedColumns lists the columns overwritten by a replace, the hash and batch id only when they are written
+func replacedColumns(embedding models.Embeddings) []string {
+	columns := []string{"embedding", "content", "created_at"}
+	if embedding.EmbeddingHash != nil {
+		columns = append(columns, "embedding_hash")
+	}
+	if embedding.BatchID != nil {
+		columns = append(columns, "batch_id")
+	}
+	return columns
+}
+
+// withoutEmbeddingHash leaves the optional embedding_hash column out of writes, so databases without it still work
+func withoutEmbeddingHash(db *gorm.DB) *gorm.DB {
+	return omitColumns(db, "embedding_hash")
+}
+
+// withoutBatchID leaves the optional batch_id column out of writes, so databases without it still work
+func withoutBatchID(db *gorm.DB) *gorm.DB {
+	return omitColumns(db, "batch_id")
+}
+
+// withoutOrphanURL leaves the optional orphan_url column out of writes, so databases without it still work
+func withoutOrphanURL(db *gorm.DB) *gorm.DB {
+	return omitColumns(db, "orphan_url")
+}
+
+// omitColumns adds columns to the ones already omitted, Omit alone would replace them
+func omitColumns(db *gorm.DB, columns ...string) *gorm.DB {
+	omits := append(append([]string{}, db.Statement.Omits...), columns...)
+	return db.Omit(omits...).Session(&gorm.Session{})
+}
+
+const writeRetryDelay = time.Second
+
+// addEmbeddingWithRetry retries a failed write up to retries times with a linear backoff, every attempt gets its own timeout
+func addEmbeddingWithRetry(db *gorm.DB, embedding models.Embeddings, conflict clause.OnConflict, retries int, timeout time.Duration, line int) (inserted bool, err error) {
+	err = writeWithRetry(db, retries, timeout, "insert", line, func(db *gorm.DB) (err error) {
+		inserted, err = addEmbedding(db, embedding, conflict)
+		return err
+	})
+	return inserted, err
+}
+
+// writeWithRetry runs a write up to retries more times after a failure with a linear backoff, it stops retrying once
+// the context of db is done
+func writeWithRetry(db *gorm.DB, retries int, timeout time.Duration, name string, line int, op func(db *gorm.DB) error) error {
+	var err error
+	for attempt := 0; attempt <= retries; attempt++ {
+		if attempt > 0 {
+			if db.Statement.Context.Err() != nil {
+				return err
+			}
+			time.Sleep(time.Duration(attempt) * writeRetryDelay)
+		}
+
+		err = withTimeout(db, timeout, name, line, op)
+		if err == nil {
+			return nil
+		}
+	}
+
+	return err
+}
+
+// slowQueryThreshold makes withTimeout log operations that take longer, 0 disables it. It is set once from
+// -slow-query before the import starts.
+var slowQueryThreshold time.Duration
+
+// withTimeout runs op with db bound to a deadline of timeout (none if timeout is 0) and logs the operation
+// and input line when the deadline is exceeded or the operation is slower than slowQueryThreshold. The deadline is
+// derived from the context of db, so cancelling it still stops the operation.
+func withTimeout(db *gorm.DB, timeout time.Duration, name string, line int, op func(db *gorm.DB) error) error {
+	if slowQueryThreshold > 0 {
+		defer func(start time.Time) {
+			if elapsed := time.Since(start); elapsed > slowQueryThreshold {
+				log.Printf("line %d: slow %s took %s", line, name, elapsed)
+			}
+		}(time.Now())
+	}
+
+	if timeout <= 0 {
+		return op(db)
+	}
+
+	ctx, cancel := context.WithTimeout(db.Statement.Context, timeout)
+	defer cancel()
+
+	err := op(db.WithContext(ctx))
+	if err != nil && db.Statement.Context.Err() == nil && (errors.Is(err, context.DeadlineExceeded) || pgconn.Timeout(err)) {
+		log.Printf("line %d: %s timed out after %s", line, name, timeout)
+	}
+
+	return err
+}
+
+// checkEntryForeignKey verifies that foreign keys on the entry_id of an embeddings table reference content_entry(id),
+// so a mismatched constraint is reported before the import instead of as a violation on every insert
+func checkEntryForeignKey(db *gorm.DB, table string) error {
+	var keys []struct {
+		Name       string
+		RefTable   string
+		RefColumn  string
+		EntryType  string
+		TargetType string
+	}
+	err := db.Raw(`SELECT c.conname AS name, c.confrelid::regclass::text AS ref_table, ra.attname AS ref_column,
+		format_type(la.atttypid, la.atttypmod) AS entry_type, format_type(ra.atttypid, ra.atttypmod) AS target_type
+		FROM pg_constraint c
+		JOIN pg_attribute la ON la.attrelid = c.conrelid AND la.attnum = c.conkey[1]
+		JOIN pg_attribute ra ON ra.attrelid = c.confrelid AND ra.attnum = c.confkey[1]
+		WHERE c.contype = 'f' AND c.conrelid = to_regclass(?) AND la.attname = 'entry_id'`,
+		table).Scan(&keys).Error
+	if err != nil {
+		return fmt.Errorf("foreign key query error: %w", err)
+	}
+
+	if len(keys) == 0 {
+		log.Printf("no foreign key on %s.entry_id, entry ids are not checked by the database", table)
+		return nil
+	}
+
+	for _, k := range keys {
+		if strings.Trim(k.RefTable, `"`) != (models.ContentEntry{}).TableName() || k.RefColumn != "id" {
+			return fmt.Errorf("foreign key %s on %s.entry_id references %s(%s), expected content_entry(id)", k.Name, table, k.RefTable, k.RefColumn)
+		}
+		if k.EntryType != k.TargetType {
+			return fmt.Errorf("foreign key %s: %s.entry_id is %s but content_entry.id is %s", k.Name, table, k.EntryType, k.TargetType)
+		}
+	}
+
+	return nil
+}
+
+// isPartitioned reports whether the table uses declarative partitioning
+func isPartitioned(db *gorm.DB, table string) (bool, error) {
+	var partitioned bool
+	err := db.Raw("SELECT EXISTS (SELECT 1 FROM pg_partitioned_table WHERE partrelid = to_regclass(?))", table).Scan(&partitioned).Error
+	return partitioned, err
+}
+
+// partitionName derives the name of the partition holding a type, the hash keeps types that sanitize to the same
+// name apart and the result within the identifier length limit
+func partitionName(table, embeddingType string) string {
+	name := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return '_'
+	}, strings.ToLower(embeddingType))
+	if len(name) > 40 {
+		name = name[:40]
+	}
+
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(embeddingType))
+
+	return fmt.Sprintf("%s_%s_%08x", table, name, h.Sum32())
+}
+
+// createPartition creates the list partition of the embeddings table for the type unless it already exists.
+// The table must be partitioned by list on type.
+func createPartition(db *gorm.DB, table, embeddingType string) error {
+	quoteLiteral := func(s string) string { return `'` + strings.ReplaceAll(s, `'`, `''`) + `'` }
+
+	return db.Exec(fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s PARTITION OF %s FOR VALUES IN (%s)",
+		quoteIdent(partitionName(table, embeddingType)), quoteIdent(table), quoteLiteral(embeddingType))).Error
+}
+
+// hasUniqueIndex reports whether the table has a valid non partial unique index on exactly the columns,
+// which ON CONFLICT needs to infer the conflict target
+func hasUniqueIndex(db *gorm.DB, table string, columns ...string) (bool, error) {
+	sorted := append([]string(nil), columns...)
+	sort.Strings(sorted)
+
+	var count int64
+	err := db.Raw(`SELECT count(*) FROM pg_index i
+		WHERE i.indrelid = to_regclass(?) AND i.indisunique AND i.indisvalid AND i.indpred IS NULL
+		AND (SELECT array_agg(a.attname::text ORDER BY a.attname::text) FROM pg_attribute a
+			WHERE a.attrelid = i.indrelid AND a.attnum = ANY(i.indkey)) = ?::text[]`,
+		table, pq.StringArray(sorted)).Scan(&count).Error
+	return count > 0, err
+}
+
+// createNaturalKeyIndex creates the unique index on (entry_id, type) that insertConflict and replace-if-newer
+// target. It is built without blocking writes unless the table is partitioned, which postgres doesn't support.
+// A concurrent build that fails, e.g. on duplicates already stored, leaves an invalid index that is dropped again.
+func createNaturalKeyIndex(db *gorm.DB, table string, partitioned bool) error {
+	ddl := naturalKeyIndexDDL(table, !partitioned)
+	log.Println(ddl)
+	if err := db.Exec(ddl).Error; err != nil {
+		if !partitioned {
+			if dropErr := db.Exec("DROP INDEX CONCURRENTLY IF EXISTS " + quoteIdent(naturalKeyIndexName(table))).Error; dropErr != nil {
+				log.Println("unable to drop the invalid index", dropErr)
+			}
+		}
+		return err
+	}
+
+	return nil
+}
+
+func naturalKeyIndexName(table string) string {
+	return table + "_entry_id_type_key"
+}
+
+// naturalKeyIndexDDL returns the statement creating the unique index on (entry_id, type) of table
+func naturalKeyIndexDDL(table string, concurrently bool) string {
+	mode := ""
+	if concurrently {
+		mode = " CONCURRENTLY"
+	}
+
+	return fmt.Sprintf("CREATE UNIQUE INDEX%s IF NOT EXISTS %s ON %s (entry_id, type)", mode, quoteIdent(naturalKeyIndexName(table)), quoteIdent(table))
+}
+
+func quoteIdent(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+}
+
+// hasURLIndex reports whether content entries have a non partial btree index led by urlColumn, which the exact
+// url lookup needs to avoid a sequential scan
+func hasURLIndex(db *gorm.DB, urlColumn string) (bool, error) {
+	var keys []string
+	err := db.Raw(`SELECT pg_get_indexdef(i.indexrelid, 1, true) FROM pg_index i
+		JOIN pg_class c ON c.oid = i.indexrelid JOIN pg_am am ON am.oid = c.relam
+		WHERE i.indrelid = to_regclass(?) AND i.indpred IS NULL AND am.amname = 'btree'`,
+		models.ContentEntry{}.TableName()).Scan(&keys).Error
+	if err != nil {
+		return false, err
+	}
+
+	// postgres renders entry_data->>'url' as (entry_data ->> 'url'::text)
+	normalize := strings.NewReplacer(" ", "", "(", "", ")", "", "::text", "")
+	for _, key := range keys {
+		if normalize.Replace(key) == normalize.Replace(urlColumn) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// createURLIndex builds the index hasURLIndex looks for without blocking writes to content entries
+func createURLIndex(db *gorm.DB, urlColumn string) error {
+	return db.Exec(urlIndexDDL(urlColumn, true)).Error
+}
+
+// urlIndexDDL returns the statement creating the index hasURLIndex looks for, urlColumn is one of urlSources
+func urlIndexDDL(urlColumn string, concurrently bool) string {
+	mode := ""
+	if concurrently {
+		mode = " CONCURRENTLY"
+	}
+
+	table := models.ContentEntry{}.TableName()
+	return fmt.Sprintf("CREATE INDEX%s IF NOT EXISTS %s_url_idx ON %s ((%s))", mode, table, table, urlColumn)
+}
+
+// warmUp opens a connection and runs the per-record lookups once with values that match nothing, so connection
+// setup and planning of the first statements happen before the import is timed
+func warmUp(ctx context.Context, db *gorm.DB, urlColumn string) error {
+	sqlDB, err := db.DB()
+	if err != nil {
+		return err
+	}
+	if err := sqlDB.PingContext(ctx); err != nil {
+		return err
+	}
+
+	db = db.WithContext(ctx)
+	if _, err := findEntryByURL(db, urlColumn, ""); err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+	_, _, err = embeddingExists(db, uuid.UUID{}, "")
+	return err
+}
+
+// utcTimezones are the timezone names postgres may report for UTC
+var utcTimezones = []string{"UTC", "Etc/UTC", "UCT", "Etc/UCT", "Universal", "Etc/Universal", "Zulu", "Etc/Zulu"}
+
+// sessionSettingProblems reports session settings that differ from what the import assumes: UTF-8 text, and UTC so
+// created_at, written from time.Now().UTC(), reads back the same in queries that convert to the session timezone
+func sessionSettingProblems(db *gorm.DB) ([]string, error) {
+	var encoding, timezone string
+	if err := db.Raw("SHOW client_encoding").Scan(&encoding).Error; err != nil {
+		return nil, fmt.Errorf("client_encoding query error: %w", err)
+	}
+	if err := db.Raw("SHOW timezone").Scan(&timezone).Error; err != nil {
+		return nil, fmt.Errorf("timezone query error: %w", err)
+	}
+
+	var problems []string
+	if !strings.EqualFold(encoding, "UTF8") {
+		problems = append(problems, fmt.Sprintf("client_encoding is %s, expected UTF8", encoding))
+	}
+
+	utc := false
+	for _, name := range utcTimezones {
+		utc = utc || strings.EqualFold(timezone, name)
+	}
+	if !utc {
+		problems = append(problems, fmt.Sprintf("timezone is %s, expected UTC", timezone))
+	}
+
+	return problems, nil
+}
+
+// upsertContentEntry creates the content entry or replaces its entry_data, the JSON is stored as given
+func upsertContentEntry(db *gorm.DB, id uuid.UUID, entryData string, now time.Time) error {
+	// a fresh statement, db may be scoped to the embeddings table
+	return db.Session(&gorm.Session{NewDB: true}).Model(&models.ContentEntry{}).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"entry_data", "updated_at"}),
+	}).Create(map[string]interface{}{
+		"id":         id,
+		"entry_data": gorm.Expr("?::jsonb", entryData),
+		"created_at": now,
+		"updated_at": now,
+	}).Error
+}
+
+// entryExists reports whether a content entry with the id is still present
+func entryExists(db *gorm.DB, id uuid.UUID) (bool, error) {
+	var count int64
+	err := db.Model(&models.ContentEntry{}).Where("id = ?", id).Limit(1).Count(&count).Error
+	return count > 0, err
+}
+
+// acquireImportLock takes a session advisory lock keyed by name on a dedicated connection, which is held until
+// the returned release is called. It fails right away if another session holds the lock.
+func acquireImportLock(ctx context.Context, db *gorm.DB, name string) (release func(), err error) {
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := sqlDB.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var locked bool
+	if err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock(hashtext($1))", name).Scan(&locked); err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("advisory lock error: %w", err)
+	}
+	if !locked {
+		_ = conn.Close()
+		return nil, fmt.Errorf("another import holds the lock %q", name)
+	}
+
+	return func() {
+		if _, err := conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock(hashtext($1))", name); err != nil {
+			log.Println("advisory unlock error", err)
+		}
+		if err := conn.Close(); err != nil {
+			log.Println("error closing lock connection", err)
+		}
+	}, nil
+}
+
+// embeddingExists looks up an embedding by its natural key (entry_id, type) and returns the existing ID if found.
+// Only a missing row means false, any other query error is returned so the caller applies its error policy.
+func embeddingExists(db *gorm.DB, entryID uuid.UUID, embeddingType string) (bool, uuid.UUID, error) {
+	var data models.Embeddings
+	err := db.Select("id").Take(&data, "entry_id = ? AND type = ?", entryID, embeddingType).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return false, uuid.UUID{}, nil
+		}
+		return false, uuid.UUID{}, err
+	}
+
+	return true, data.ID, nil
+}
+
+// updateEmbeddingMetadata sets the type, and the content unless setContent is false, of the stored embedding
+// (entryID, storedType) without writing its vector. It reports whether a row was updated.
+func updateEmbeddingMetadata(db *gorm.DB, entryID uuid.UUID, storedType, newType string, setContent bool, content *string) (bool, error) {
+	updates := map[string]interface{}{"type": newType}
+	if setContent {
+		updates["content"] = content
+	}
+
+	res := db.Where("entry_id = ? AND type = ?", entryID, storedType).Updates(updates)
+	return res.RowsAffected > 0, res.Error
+}
+
+// embeddingColumnType returns the udt name of the embedding column of table: _float4 or _float8 for real[], vector
+// or bytea
+func embeddingColumnType(db *gorm.DB, table string) (string, error) {
+	var udtName string
+	err := db.Raw(`SELECT udt_name FROM information_schema.columns
+		WHERE table_schema = current_schema() AND table_name = ? AND column_name = 'embedding'`, table).Row().Scan(&udtName)
+	if err != nil {
+		return "", fmt.Errorf("embedding column type query error: %w", err)
+	}
+
+	return udtName, nil
+}
+
+// hasColumn reports whether the table has the column
+func hasColumn(db *gorm.DB, table, column string) (bool, error) {
+	var count int64
+	err := db.Raw(`SELECT count(*) FROM information_schema.columns
+		WHERE table_schema = current_schema() AND table_name = ? AND column_name = ?`, table, column).Row().Scan(&count)
+	return count > 0, err
+}
+
+// orphanEmbedding is an embedding stored without entry_id by an import in orphan mode
+type orphanEmbedding struct {
+	ID        uuid.UUID
+	Type      string
+	OrphanURL string
+}
+
+// findOrphanEmbeddings returns up to limit orphan embeddings of the table that kept their url, 0 returns all of them
+func findOrphanEmbeddings(db *gorm.DB, table string, limit int) ([]orphanEmbedding, error) {
+	query := db.Table(table).
+		Select("id, type, orphan_url").
+		Where("entry_id IS NULL AND orphan_url IS NOT NULL").
+		Order("id")
+	if limit > 0 {
+		query = query.Limit(limit)
+	}
+
+	var orphans []orphanEmbedding
+	return orphans, query.Scan(&orphans).Error
+}
+
+// attachOrphanEmbedding sets the entry_id and type of an orphan embedding and clears its url. It returns false if the
+// embedding was attached meanwhile, or if an embedding of that entry and type is already stored.
+func attachOrphanEmbedding(db *gorm.DB, table string, id, entryID uuid.UUID, embeddingType string) (bool, error) {
+	res := db.Table(table).
+		Where("id = ? AND entry_id IS NULL", id).
+		Updates(map[string]interface{}{"entry_id": entryID, "type": embeddingType, "orphan_url": nil})
+	if res.Error != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(res.Error, &pgErr) && pgErr.Code == pgUniqueViolation {
+			return false, nil
+		}
+		return false, res.Error
+	}
+
+	return res.RowsAffected > 0, nil
+}
+
+// columnNullable reports whether the column of the table accepts NULL
+func columnNullable(db *gorm.DB, table, column string) (bool, error) {
+	var nullable string
+	err := db.Raw(`SELECT is_nullable FROM information_schema.columns
+		WHERE table_schema = current_schema() AND table_name = ? AND column_name = ?`, table, column).Row().Scan(&nullable)
+	return nullable == "YES", err
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/denisb0/import_embeddings/models"
+)
+
+func runDeleteBatch(ctx context.Context, common commonOptions, args []string) error {
+	flags := flag.NewFlagSet("delete-batch", flag.ExitOnError)
+	batchID := flags.String("batch-id", "", "batch id printed by the import run to undo")
+	tables := flags.String("tables", models.Embeddings{}.TableName(), "comma separated tables the run wrote to, add the -type-to-table ones")
+	secondary := flags.Bool("secondary", false, "also delete the batch from the SECONDARY_DB_ database, in its own transaction")
+	dryRun := flags.Bool("dry-run", false, "count the embeddings of the batch without deleting them")
+	_ = flags.Parse(args)
+
+	if *batchID == "" {
+		return errors.New("-batch-id is required")
+	}
+	id, err := uuid.Parse(*batchID)
+	if err != nil {
+		return fmt.Errorf("invalid -batch-id: %w", err)
+	}
+
+	db, err := getDBConn(common)
+	if err != nil {
+		return err
+	}
+
+	names, dbs := []string{"database"}, []*gorm.DB{db}
+	if *secondary {
+		secondaryDB, err := getSecondaryDBConn(common)
+		if err != nil {
+			return err
+		}
+		if secondaryDB == nil {
+			return errors.New("-secondary needs SECONDARY_DB_HOST")
+		}
+		names, dbs = append(names, "secondary database"), append(dbs, secondaryDB)
+	}
+
+	tableNames := splitList(*tables)
+	for i, db := range dbs {
+		counts, err := deleteBatch(db.WithContext(ctx), tableNames, id, *dryRun)
+		if err != nil {
+			return fmt.Errorf("%s: %w", names[i], err)
+		}
+
+		var total int64
+		for j, table := range tableNames {
+			fmt.Printf("%s %s: %d embeddings\n", names[i], table, counts[j])
+			total += counts[j]
+		}
+		if *dryRun {
+			fmt.Printf("%s: %d embeddings of batch %s would be deleted\n", names[i], total, id)
+		} else {
+			fmt.Printf("%s: %d embeddings of batch %s deleted\n", names[i], total, id)
+		}
+	}
+
+	return nil
+}
+
+// deleteBatch deletes the embeddings of a batch from every table in one transaction and returns the count per table,
+// a failure on any table rolls all of them back. With dryRun the rows are only counted.
+func deleteBatch(db *gorm.DB, tables []string, batchID uuid.UUID, dryRun bool) ([]int64, error) {
+	counts := make([]int64, len(tables))
+	err := db.Transaction(func(tx *gorm.DB) error {
+		for i, table := range tables {
+			if dryRun {
+				if err := tx.Table(table).Where("batch_id = ?", batchID).Count(&counts[i]).Error; err != nil {
+					return fmt.Errorf("%s count error: %w", table, err)
+				}
+				continue
+			}
+
+			res := tx.Table(table).Where("batch_id = ?", batchID).Delete(&models.Embeddings{})
+			if res.Error != nil {
+				return fmt.Errorf("%s delete error: %w", table, res.Error)
+			}
+			counts[i] = res.RowsAffected
+		}
+		return nil
+	})
+
+	return counts, err
+}
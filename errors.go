@@ -0,0 +1,47 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// kinds of record failures, match them with errors.Is and get the line with errors.As on *RecordError
+var (
+	ErrConvert  = errors.New("convert")  // the record's data is invalid
+	ErrLookup   = errors.New("lookup")   // a query about the record failed
+	ErrConflict = errors.New("conflict") // the record's embedding is already stored and that is not allowed
+	ErrWrite    = errors.New("write")    // storing the record failed
+)
+
+// RecordError is the failure of one record, or of a batch of records from Line to LastLine
+type RecordError struct {
+	Line     int
+	LastLine int    // last line of a failed batch, 0 for a single record
+	Kind     error  // ErrConvert, ErrLookup, ErrConflict or ErrWrite
+	Msg      string // what failed, e.g. "find entry error"
+	Err      error  // cause, nil if Msg says it all
+}
+
+func newRecordError(line int, kind error, msg string, err error) *RecordError {
+	return &RecordError{Line: line, Kind: kind, Msg: msg, Err: err}
+}
+
+func (e *RecordError) Error() string {
+	prefix := fmt.Sprintf("line %d", e.Line)
+	if e.LastLine > 0 {
+		prefix = fmt.Sprintf("lines %d-%d", e.Line, e.LastLine)
+	}
+
+	if e.Err == nil {
+		return prefix + ": " + e.Msg
+	}
+	return prefix + ": " + e.Msg + ": " + e.Err.Error()
+}
+
+func (e *RecordError) Unwrap() error {
+	return e.Err
+}
+
+func (e *RecordError) Is(target error) bool {
+	return target == e.Kind
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// postgres storage constants used by the size estimate
+const (
+	pgTupleOverhead      = 24 + 4 // heap tuple header and line pointer
+	pgArrayOverhead      = 4 + 20 // varlena header and one dimensional array header
+	pgToastThreshold     = 2032   // rows larger than this get their largest values moved to the toast table
+	pgToastPointer       = 18
+	pgToastChunkSize     = 1996
+	pgToastChunkOverhead = pgTupleOverhead + 4 + 4 + 4 // chunk_id, chunk_seq and the chunk varlena header
+)
+
+// estimateRowBytes approximates the disk space of an embeddings row with a real[] column of dim values. Alignment,
+// free page space and indexes are ignored, and float data compresses poorly so toasted values are counted as is.
+func estimateRowBytes(dim, typeLen, contentLen int) int64 {
+	array := pgArrayOverhead + 4*dim
+	content := varlenaSize(contentLen)
+	row := pgTupleOverhead + 16 + 16 + 8 + varlenaSize(typeLen) // id, entry_id, created_at, type
+
+	// values are moved to the toast table largest first until the row fits
+	inline := row + array + content
+	var toast int
+	if inline > pgToastThreshold {
+		toast += toastedSize(array)
+		inline += pgToastPointer - array
+	}
+	if inline > pgToastThreshold {
+		toast += toastedSize(content)
+		inline += pgToastPointer - content
+	}
+
+	return int64(inline + toast)
+}
+
+// toastedSize is the space a value takes in the toast table, split into chunks stored as rows of their own
+func toastedSize(n int) int {
+	chunks := (n + pgToastChunkSize - 1) / pgToastChunkSize
+	return n + chunks*pgToastChunkOverhead
+}
+
+// varlenaSize is the stored size of a variable length value, short values get a one byte header
+func varlenaSize(n int) int {
+	if n < 127 {
+		return n + 1
+	}
+	return n + 4
+}
+
+// formatBytes renders a size with a binary unit
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// parseByteSize reads a size like 512MiB, 2G or 1048576, units are binary and the B or iB suffix is optional
+func parseByteSize(s string) (int64, error) {
+	number := strings.TrimSuffix(strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(s)), "B"), "I")
+
+	multiplier := int64(1)
+	if number != "" {
+		if i := strings.IndexByte("KMGT", number[len(number)-1]); i >= 0 {
+			multiplier <<= 10 * (i + 1)
+			number = number[:len(number)-1]
+		}
+	}
+
+	n, err := strconv.ParseInt(strings.TrimSpace(number), 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q, expected a number of bytes with an optional K, M, G or T unit", s)
+	}
+
+	return n * multiplier, nil
+}
@@ -0,0 +1,361 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/csv"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"gorm.io/gorm"
+
+	"github.com/denisb0/import_embeddings/models"
+)
+
+func runExport(ctx context.Context, common commonOptions, args []string) error {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	output := flags.String("output", "-", "path of the output file, - for stdout")
+	embeddingType := flags.String("type", "", "export only embeddings of this type")
+	floatFmt := flags.String("float-fmt", "g", "float format of embedding values: g, e or f")
+	floatPrecision := flags.Int("float-precision", -1, "digits of embedding values for -float-fmt, -1 is the shortest representation that preserves float32 exactly, which verify accepts; other formats and precisions make verify report mismatches")
+	urlSource := flags.String("url-source", "json", "where content entries keep their url: json for entry_data->>'url', column for the url column")
+	manifestPath := flags.String("manifest", "", "path of the JSON manifest describing the export, defaults to the output path with .manifest.json appended, none for stdout")
+	pageSize := flags.Int("page-size", 10000, "rows read per query, pages follow the embedding id instead of an offset")
+	checkpoint := flags.String("checkpoint", "", "file keeping the last exported embedding id, a re-run appends to the output after it")
+	entryIDsFile := flags.String("entry-ids-file", "", "export only embeddings of the content entries listed in this file, one id per line")
+	exportHeader := flags.Bool("export-header", true, "write the header row, a resumed export never writes it")
+	headerNames := flags.String("header-names", strings.Join(exportColumns, ","), "comma separated names of the embedding, url, content and type columns in the header")
+	nullContent := flags.String("null-content", "", `text written for NULL content, give import the same -null-content to store it as NULL again; the default empty string can't be told from empty content, e.g. \N can`)
+	typeToTable := flags.String("type-to-table", "", "comma separated type=table pairs the import routed to other tables, as given to import, those tables are exported too")
+	_ = flags.Parse(args)
+
+	if *floatFmt != "g" && *floatFmt != "e" && *floatFmt != "f" {
+		return fmt.Errorf("unsupported float format: %s", *floatFmt)
+	}
+	format := floatFormat{Fmt: (*floatFmt)[0], Precision: *floatPrecision}
+
+	urlColumn, ok := urlSources[*urlSource]
+	if !ok {
+		return fmt.Errorf("unsupported url source: %s", *urlSource)
+	}
+
+	if *pageSize <= 0 {
+		return errors.New("-page-size must be positive")
+	}
+
+	routes, err := parseTypeRoutes(*typeToTable, "")
+	if err != nil {
+		return err
+	}
+
+	header := splitList(*headerNames)
+	if len(header) != len(exportColumns) {
+		return fmt.Errorf("-header-names needs %d names for %s, got %d", len(exportColumns), strings.Join(exportColumns, ", "), len(header))
+	}
+
+	opts := exportOptions{
+		Tables:      routeTables(models.Embeddings{}.TableName(), routes, *embeddingType),
+		Type:        *embeddingType,
+		URLColumn:   urlColumn,
+		Format:      format,
+		PageSize:    *pageSize,
+		Checkpoint:  *checkpoint,
+		Header:      header,
+		NullContent: *nullContent,
+	}
+	if !*exportHeader {
+		opts.Header = nil
+	}
+
+	if *entryIDsFile != "" {
+		ids, err := readEntryIDList(*entryIDsFile)
+		if err != nil {
+			return fmt.Errorf("entry ids file read error: %w", err)
+		}
+		opts.EntryIDs = ids
+		log.Println("exporting only listed entries ", len(ids))
+	}
+
+	if *checkpoint != "" {
+		after, ok, err := readExportCheckpoint(*checkpoint)
+		if err != nil {
+			return fmt.Errorf("checkpoint read error: %w", err)
+		}
+		if ok {
+			// the manifest would only describe the rows of this run, not the whole file
+			if *manifestPath != "" {
+				return errors.New("-manifest can't describe a resumed export")
+			}
+			exported := false
+			for _, table := range opts.Tables {
+				exported = exported || table == after.Table
+			}
+			if !exported {
+				return fmt.Errorf("checkpoint is in table %s, which isn't exported, check -type and -type-to-table", after.Table)
+			}
+			log.Println("resuming export after embedding id ", after.ID, " of ", after.Table)
+			opts.After, opts.Header = &after, nil
+		}
+	}
+
+	db, err := getDBConn(common)
+	if err != nil {
+		return err
+	}
+
+	w := io.Writer(os.Stdout)
+	if *output != "-" {
+		mode := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+		if opts.After != nil {
+			mode = os.O_WRONLY | os.O_APPEND
+		}
+		f, err := os.OpenFile(*output, mode, 0o644)
+		if err != nil {
+			return err
+		}
+
+		defer func() {
+			if err := f.Close(); err != nil {
+				log.Println("error closing file", err)
+			}
+		}()
+
+		w = f
+
+		if *manifestPath == "" && opts.After == nil {
+			*manifestPath = *output + ".manifest.json"
+		}
+	}
+
+	checksum := sha256.New()
+	manifest, err := export(ctx, db, io.MultiWriter(w, checksum), opts)
+	if err != nil {
+		return err
+	}
+
+	log.Println("records exported ", manifest.Rows)
+
+	if *manifestPath != "" {
+		manifest.SHA256 = hex.EncodeToString(checksum.Sum(nil))
+		if err := writeManifest(*manifestPath, manifest); err != nil {
+			return fmt.Errorf("manifest write error: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// floatFormat holds strconv.FormatFloat format and precision used for embedding values
+type floatFormat struct {
+	Fmt       byte
+	Precision int
+}
+
+type exportOptions struct {
+	Tables      []string // embeddings tables exported one after the other
+	Type        string   // export only embeddings of this type, empty for all
+	URLColumn   string   // SQL expression of the content entry url, from urlSources
+	Format      floatFormat
+	PageSize    int
+	After       *exportPosition // export only embeddings after this one, tables before its table are done, nil for all
+	Checkpoint  string          // file receiving the last exported position after every page, empty for none
+	Header      []string        // CSV header written first, nil for none
+	EntryIDs    []string        // export only embeddings of these content entries, nil for all
+	NullContent string          // written for NULL content
+}
+
+// exportColumns are the columns written by export, in order
+var exportColumns = []string{"embedding", "url", "content", "type"}
+
+// export writes stored embeddings in the import format: embedding, url, content, type and describes them in
+// the returned manifest, except for the checksum. Orphan embeddings, without a content entry, are exported with
+// their orphan_url when the table has it and an empty url otherwise. Tables are exported one after the other, the rows of each are
+// read in pages ordered by id, every page starts after the last id of the previous one, so late pages cost as much
+// as early ones.
+func export(ctx context.Context, db *gorm.DB, w io.Writer, opts exportOptions) (exportManifest, error) {
+	manifest := exportManifest{Types: []string{}}
+
+	csvWriter := csv.NewWriter(w)
+	if opts.Header != nil {
+		if err := csvWriter.Write(opts.Header); err != nil {
+			return manifest, err
+		}
+	}
+
+	resumed := opts.After == nil
+	for _, table := range opts.Tables {
+		var after *uuid.UUID
+		if !resumed {
+			if table != opts.After.Table {
+				continue
+			}
+			resumed, after = true, &opts.After.ID
+		}
+
+		if err := exportTable(ctx, db, table, after, csvWriter, opts, &manifest); err != nil {
+			return manifest, fmt.Errorf("%s: %w", table, err)
+		}
+	}
+
+	return manifest, nil
+}
+
+// exportTable writes the embeddings of table with an id greater than after, all of them if it is nil
+func exportTable(ctx context.Context, db *gorm.DB, table string, after *uuid.UUID, csvWriter *csv.Writer, opts exportOptions, manifest *exportManifest) error {
+	db = db.WithContext(ctx)
+
+	urlExpr := "ce." + opts.URLColumn
+	hasOrphanURL, err := hasColumn(db, table, "orphan_url")
+	if err != nil {
+		return fmt.Errorf("orphan_url column query error: %w", err)
+	}
+	if hasOrphanURL {
+		urlExpr = "coalesce(" + urlExpr + ", e.orphan_url)"
+	}
+
+	columnType, err := embeddingColumnType(db, table)
+	if err != nil {
+		return err
+	}
+
+	for {
+		query := db.Table(quoteIdent(table) + " e").
+			Select("e.id, e.embedding, " + urlExpr + ", e.content, e.type, e.created_at").
+			Joins("LEFT JOIN content_entry ce ON ce.id = e.entry_id").
+			Order("e.id").
+			Limit(opts.PageSize)
+		if opts.Type != "" {
+			query = query.Where("e.type = ?", opts.Type)
+		}
+		if opts.EntryIDs != nil {
+			// a single array parameter, an IN list of many ids would hit the bind parameter limit
+			query = query.Where("e.entry_id = ANY(?::uuid[])", pq.StringArray(opts.EntryIDs))
+		}
+		if after != nil {
+			query = query.Where("e.id > ?", *after)
+		}
+
+		last, n, err := exportPage(query, columnType, csvWriter, opts, manifest)
+		if err != nil {
+			return err
+		}
+
+		csvWriter.Flush()
+		if err := csvWriter.Error(); err != nil {
+			return err
+		}
+
+		if n > 0 {
+			after = &last
+			if opts.Checkpoint != "" {
+				if err := writeExportCheckpoint(opts.Checkpoint, exportPosition{Table: table, ID: last}); err != nil {
+					return fmt.Errorf("checkpoint write error: %w", err)
+				}
+			}
+		}
+
+		if n < opts.PageSize {
+			return nil
+		}
+	}
+}
+
+// readEntryIDList reads a newline delimited list of content entry ids, blank lines and repeated ids are ignored
+func readEntryIDList(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	ids := []string{}
+	seen := make(map[uuid.UUID]struct{})
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+
+		id, err := uuid.Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid entry id %q: %w", line, text, err)
+		}
+		if _, ok := seen[id]; !ok {
+			seen[id] = struct{}{}
+			ids = append(ids, id.String())
+		}
+	}
+
+	return ids, scanner.Err()
+}
+
+// exportPage writes the rows of query and returns the id of the last one and how many there were, embeddings are
+// decoded from columnType, see models.DecodeEmbedding
+func exportPage(query *gorm.DB, columnType string, csvWriter *csv.Writer, opts exportOptions, manifest *exportManifest) (uuid.UUID, int, error) {
+	rows, err := query.Rows()
+	if err != nil {
+		return uuid.UUID{}, 0, fmt.Errorf("export query error: %w", err)
+	}
+	defer rows.Close()
+
+	var last uuid.UUID
+	var n int
+	for rows.Next() {
+		var stored interface{}
+		var url, content sql.NullString
+		var typ string
+		var createdAt time.Time
+		if err := rows.Scan(&last, &stored, &url, &content, &typ, &createdAt); err != nil {
+			return last, n, fmt.Errorf("export scan error: %w", err)
+		}
+
+		embedding, err := models.DecodeEmbedding(columnType, stored)
+		if err != nil {
+			return last, n, fmt.Errorf("embedding %s: %w", last, err)
+		}
+
+		if !content.Valid {
+			content.String = opts.NullContent
+		}
+		if err := csvWriter.Write([]string{formatEmbedding(embedding, opts.Format), url.String, content.String, typ}); err != nil {
+			return last, n, err
+		}
+		manifest.add(len(embedding), typ, createdAt)
+		n++
+	}
+
+	if err := rows.Err(); err != nil {
+		return last, n, fmt.Errorf("export query error: %w", err)
+	}
+
+	return last, n, nil
+}
+
+// formatEmbedding renders a vector the way the importer reads it. The default format, g with precision -1, is the
+// canonical one: the shortest text of every float32 value, which verify reports no mismatches for.
+func formatEmbedding(vector []float32, format floatFormat) string {
+	buf := make([]byte, 0, len(vector)*12)
+	buf = append(buf, '[')
+	for i, v := range vector {
+		if i > 0 {
+			buf = append(buf, ", "...)
+		}
+		buf = strconv.AppendFloat(buf, float64(v), format.Fmt, format.Precision, 32)
+	}
+	buf = append(buf, ']')
+
+	return string(buf)
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"math"
+	"math/rand"
+	"strconv"
+	"testing"
+)
+
+func TestExportVerifyRoundTrip(t *testing.T) {
+	const dim = 64
+
+	rnd := rand.New(rand.NewSource(1))
+	vectors := [][]float32{
+		make([]float32, dim),
+	}
+	extremes := make([]float32, dim)
+	for i := range extremes {
+		switch i % 4 {
+		case 0:
+			extremes[i] = 0.1
+		case 1:
+			extremes[i] = -math.MaxFloat32
+		case 2:
+			extremes[i] = math.SmallestNonzeroFloat32
+		default:
+			extremes[i] = 1e-7
+		}
+	}
+	vectors = append(vectors, extremes)
+	for i := 0; i < 50; i++ {
+		vector := make([]float32, dim)
+		for j := range vector {
+			vector[j] = float32(rnd.NormFloat64() / 40)
+		}
+		vectors = append(vectors, vector)
+	}
+
+	format := floatFormat{Fmt: 'g', Precision: -1}
+
+	var buf bytes.Buffer
+	csvWriter := csv.NewWriter(&buf)
+	if err := csvWriter.Write(exportColumns); err != nil {
+		t.Fatal(err)
+	}
+	for _, vector := range vectors {
+		row := []string{formatEmbedding(vector, format), "https://example.com/a", "content", "title"}
+		if err := csvWriter.Write(row); err != nil {
+			t.Fatal(err)
+		}
+	}
+	csvWriter.Flush()
+	if err := csvWriter.Error(); err != nil {
+		t.Fatal(err)
+	}
+	exported := buf.String()
+
+	layout := embeddingLayout{Dim: dim, Delimiter: defaultVectorDelimiter}
+	mismatches, err := verify(&buf, layout, 0, nil, floatTolerance{Exact: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mismatches) != 0 {
+		t.Errorf("verify reported %d mismatches, first %+v", len(mismatches), mismatches[0])
+	}
+
+	// values are written as their shortest float32 text and the importer reads them back exactly
+	records, err := csv.NewReader(bytes.NewBufferString(exported)).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for line, record := range records[1:] {
+		strValues := splitEmbedding(record[0], defaultVectorDelimiter)
+		for i, strValue := range strValues {
+			if want := strconv.FormatFloat(float64(vectors[line][i]), 'g', -1, 32); strValue != want {
+				t.Fatalf("line %d position %d: exported %s, want %s", line, i, strValue, want)
+			}
+			value, err := parseFloat32(strValue, i)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if math.Float32bits(value) != math.Float32bits(vectors[line][i]) {
+				t.Fatalf("line %d position %d: read %g, exported %g", line, i, value, vectors[line][i])
+			}
+		}
+	}
+}
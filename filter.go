@@ -0,0 +1,364 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// recordFilter decides whether a record is imported, see compileFilter for the expression language
+type recordFilter func(record []string) bool
+
+// compileFilter parses a filter expression over the columns of header. The language is:
+//
+//	expr     = and { "||" and }
+//	and      = unary { "&&" unary }
+//	unary    = "!" unary | "(" expr ")" | operand op operand
+//	op       = "==" | "!=" | "<" | "<=" | ">" | ">=" | "=~"
+//	operand  = column | "len(" column ")" | number | 'string' | "string"
+//
+// A column is a header name made of letters, digits and underscores and evaluates to the record's value.
+// Comparisons are numeric when both sides are numbers, a column compared with a number is parsed as one and the
+// comparison is false if that fails. Otherwise strings are compared. =~ matches the left side against the regular
+// expression on the right, which must be a string. For example: len(content) > 10 && type =~ '^azure_'
+func compileFilter(expression string, header []string) (recordFilter, error) {
+	tokens, err := tokenizeFilter(expression)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &filterParser{tokens: tokens, header: header}
+	filter, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %q in filter", p.tokens[p.pos].text)
+	}
+
+	return filter, nil
+}
+
+type filterTokenKind int
+
+const (
+	filterIdent filterTokenKind = iota
+	filterNumber
+	filterString
+	filterOp
+)
+
+type filterToken struct {
+	kind filterTokenKind
+	text string
+}
+
+// filterOps are the operators and punctuation, two character ones first so they win over their prefixes
+var filterOps = []string{"==", "!=", "<=", ">=", "=~", "&&", "||", "<", ">", "!", "(", ")"}
+
+func tokenizeFilter(expression string) ([]filterToken, error) {
+	var tokens []filterToken
+	rest := expression
+	for {
+		rest = strings.TrimLeftFunc(rest, unicode.IsSpace)
+		if rest == "" {
+			return tokens, nil
+		}
+
+		c := rest[0]
+		switch {
+		case c == '\'' || c == '"':
+			end := strings.IndexByte(rest[1:], c)
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated string in filter: %s", rest)
+			}
+			tokens = append(tokens, filterToken{kind: filterString, text: rest[1 : end+1]})
+			rest = rest[end+2:]
+		case c == '_' || unicode.IsLetter(rune(c)):
+			n := strings.IndexFunc(rest, func(r rune) bool { return r != '_' && !unicode.IsLetter(r) && !unicode.IsDigit(r) })
+			if n < 0 {
+				n = len(rest)
+			}
+			tokens = append(tokens, filterToken{kind: filterIdent, text: rest[:n]})
+			rest = rest[n:]
+		case c == '-' || c == '.' || unicode.IsDigit(rune(c)):
+			n := filterNumberLength(rest)
+			tokens = append(tokens, filterToken{kind: filterNumber, text: rest[:n]})
+			rest = rest[n:]
+		default:
+			op := ""
+			for _, candidate := range filterOps {
+				if strings.HasPrefix(rest, candidate) {
+					op = candidate
+					break
+				}
+			}
+			if op == "" {
+				return nil, fmt.Errorf("unexpected %q in filter", rest[:1])
+			}
+			tokens = append(tokens, filterToken{kind: filterOp, text: op})
+			rest = rest[len(op):]
+		}
+	}
+}
+
+// filterNumberLength returns the length of the number s starts with: digits, dots and an exponent, which can have a
+// sign, e.g. 1e-5. The first byte is taken as is, it can be a minus sign.
+func filterNumberLength(s string) int {
+	n := 1
+	for ; n < len(s); n++ {
+		c := s[n]
+		switch {
+		case c == '.' || c == 'e' || c == 'E' || unicode.IsDigit(rune(c)):
+		case (c == '+' || c == '-') && (s[n-1] == 'e' || s[n-1] == 'E'):
+		default:
+			return n
+		}
+	}
+
+	return n
+}
+
+type filterParser struct {
+	tokens []filterToken
+	pos    int
+	header []string
+}
+
+// filterValue is an evaluated operand, numbers keep their text in str too
+type filterValue struct {
+	str   string
+	num   float64
+	isNum bool
+}
+
+type filterOperand func(record []string) filterValue
+
+func (p *filterParser) peek(text string) bool {
+	return p.pos < len(p.tokens) && p.tokens[p.pos].kind == filterOp && p.tokens[p.pos].text == text
+}
+
+func (p *filterParser) next() (filterToken, error) {
+	if p.pos >= len(p.tokens) {
+		return filterToken{}, fmt.Errorf("unexpected end of filter")
+	}
+	p.pos++
+	return p.tokens[p.pos-1], nil
+}
+
+func (p *filterParser) expect(text string) error {
+	if !p.peek(text) {
+		if p.pos >= len(p.tokens) {
+			return fmt.Errorf("expected %q at the end of filter", text)
+		}
+		return fmt.Errorf("expected %q in filter, got %q", text, p.tokens[p.pos].text)
+	}
+	p.pos++
+	return nil
+}
+
+func (p *filterParser) parseOr() (recordFilter, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.peek("||") {
+		p.pos++
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(record []string) bool { return l(record) || right(record) }
+	}
+
+	return left, nil
+}
+
+func (p *filterParser) parseAnd() (recordFilter, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.peek("&&") {
+		p.pos++
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(record []string) bool { return l(record) && right(record) }
+	}
+
+	return left, nil
+}
+
+func (p *filterParser) parseUnary() (recordFilter, error) {
+	switch {
+	case p.peek("!"):
+		p.pos++
+		inner, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return func(record []string) bool { return !inner(record) }, nil
+	case p.peek("("):
+		p.pos++
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		return inner, p.expect(")")
+	}
+
+	return p.parseComparison()
+}
+
+func (p *filterParser) parseComparison() (recordFilter, error) {
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	op, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	if op.kind != filterOp {
+		return nil, fmt.Errorf("expected a comparison in filter, got %q", op.text)
+	}
+
+	if op.text == "=~" {
+		pattern, err := p.next()
+		if err != nil {
+			return nil, err
+		}
+		if pattern.kind != filterString {
+			return nil, fmt.Errorf("=~ needs a string pattern in filter, got %q", pattern.text)
+		}
+		re, err := regexp.Compile(pattern.text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern in filter: %w", err)
+		}
+		return func(record []string) bool { return re.MatchString(left(record).str) }, nil
+	}
+
+	right, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	var holds func(c int) bool
+	switch op.text {
+	case "==":
+		holds = func(c int) bool { return c == 0 }
+	case "!=":
+		holds = func(c int) bool { return c != 0 }
+	case "<":
+		holds = func(c int) bool { return c < 0 }
+	case "<=":
+		holds = func(c int) bool { return c <= 0 }
+	case ">":
+		holds = func(c int) bool { return c > 0 }
+	case ">=":
+		holds = func(c int) bool { return c >= 0 }
+	default:
+		return nil, fmt.Errorf("expected a comparison in filter, got %q", op.text)
+	}
+
+	return func(record []string) bool {
+		c, ok := compareFilterValues(left(record), right(record))
+		return ok && holds(c)
+	}, nil
+}
+
+// compareFilterValues compares numerically when either side is a number, ok is false if the other side isn't one
+func compareFilterValues(a, b filterValue) (int, bool) {
+	if !a.isNum && !b.isNum {
+		return strings.Compare(a.str, b.str), true
+	}
+
+	for _, v := range []*filterValue{&a, &b} {
+		if !v.isNum {
+			n, err := strconv.ParseFloat(strings.TrimSpace(v.str), 64)
+			if err != nil {
+				return 0, false
+			}
+			v.num = n
+		}
+	}
+
+	switch {
+	case a.num < b.num:
+		return -1, true
+	case a.num > b.num:
+		return 1, true
+	default:
+		return 0, true
+	}
+}
+
+func (p *filterParser) parseOperand() (filterOperand, error) {
+	tok, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+
+	switch tok.kind {
+	case filterNumber:
+		n, err := strconv.ParseFloat(tok.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q in filter", tok.text)
+		}
+		v := filterValue{str: tok.text, num: n, isNum: true}
+		return func([]string) filterValue { return v }, nil
+	case filterString:
+		v := filterValue{str: tok.text}
+		return func([]string) filterValue { return v }, nil
+	case filterIdent:
+		if tok.text == "len" && p.peek("(") {
+			p.pos++
+			column, err := p.parseColumn()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(")"); err != nil {
+				return nil, err
+			}
+			return func(record []string) filterValue {
+				n := len([]rune(record[column]))
+				return filterValue{str: strconv.Itoa(n), num: float64(n), isNum: true}
+			}, nil
+		}
+		p.pos--
+		column, err := p.parseColumn()
+		if err != nil {
+			return nil, err
+		}
+		return func(record []string) filterValue { return filterValue{str: record[column]} }, nil
+	}
+
+	return nil, fmt.Errorf("expected a column, number or string in filter, got %q", tok.text)
+}
+
+func (p *filterParser) parseColumn() (int, error) {
+	tok, err := p.next()
+	if err != nil {
+		return 0, err
+	}
+	if tok.kind != filterIdent {
+		return 0, fmt.Errorf("expected a column in filter, got %q", tok.text)
+	}
+
+	for i, name := range p.header {
+		if name == tok.text {
+			return i, nil
+		}
+	}
+
+	return 0, fmt.Errorf("filter column %q not found in header %v", tok.text, p.header)
+}
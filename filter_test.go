@@ -0,0 +1,73 @@
+package main
+
+import "testing"
+
+func TestCompileFilter(t *testing.T) {
+	header := []string{"url", "content", "type", "score"}
+	record := func(content, typ, score string) []string {
+		return []string{"https://example.com/a", content, typ, score}
+	}
+
+	tests := []struct {
+		expression string
+		record     []string
+		want       bool
+		wantErr    string
+	}{
+		{expression: "type == 'title'", record: record("abc", "title", "1"), want: true},
+		{expression: `type != "title"`, record: record("abc", "title", "1"), want: false},
+		{expression: "content < 'b'", record: record("abc", "title", "1"), want: true},
+		{expression: "score > 1e-5", record: record("abc", "title", "0.001"), want: true},
+		{expression: "score > 1e-5", record: record("abc", "title", "0.000001"), want: false},
+		{expression: "score >= 2E+3", record: record("abc", "title", "2000"), want: true},
+		{expression: "score > -1.5e-3", record: record("abc", "title", "-0.001"), want: true},
+		{expression: "score == -1.5", record: record("abc", "title", " -1.5 "), want: true},
+		{expression: "1 < 2", record: record("abc", "title", "1"), want: true},
+		{expression: "len(content) > 4", record: record("héllo", "title", "1"), want: true},
+		{expression: "len(content) <= 4", record: record("héllo", "title", "1"), want: false},
+		{expression: "type =~ '^azure_'", record: record("abc", "azure_title", "1"), want: true},
+		{expression: "type =~ '^azure_'", record: record("abc", "title", "1"), want: false},
+		{expression: "!(type == 'title')", record: record("abc", "title", "1"), want: false},
+		{expression: "!type == 'body' && score > 0", record: record("abc", "title", "1"), want: true},
+		// && binds tighter than ||
+		{expression: "type == 'title' || type == 'body' && score > 5", record: record("abc", "title", "1"), want: true},
+		{expression: "(type == 'title' || type == 'body') && score > 5", record: record("abc", "title", "1"), want: false},
+		// a column that isn't a number makes every numeric comparison false
+		{expression: "score > 1", record: record("abc", "title", "n/a"), want: false},
+		{expression: "score <= 1", record: record("abc", "title", "n/a"), want: false},
+		{expression: "score != 1", record: record("abc", "title", "n/a"), want: false},
+		{expression: "!(score > 1)", record: record("abc", "title", "n/a"), want: true},
+		// errors
+		{expression: "name == 'a'", wantErr: `filter column "name" not found in header [url content type score]`},
+		{expression: "type == 'a", wantErr: "unterminated string in filter: 'a"},
+		{expression: "type ==", wantErr: "unexpected end of filter"},
+		{expression: "type $ 1", wantErr: `unexpected "$" in filter`},
+		{expression: "score > 1..2", wantErr: `invalid number "1..2" in filter`},
+		{expression: "score > 1e", wantErr: `invalid number "1e" in filter`},
+		{expression: "(type == 'a'", wantErr: `expected ")" at the end of filter`},
+		{expression: "type =~ 1", wantErr: `=~ needs a string pattern in filter, got "1"`},
+		{expression: "type =~ '('", wantErr: "invalid pattern in filter: error parsing regexp: missing closing ): `(`"},
+		{expression: "type 'a'", wantErr: `expected a comparison in filter, got "a"`},
+		{expression: "type && 1", wantErr: `expected a comparison in filter, got "&&"`},
+		{expression: "type == 'a' type", wantErr: `unexpected "type" in filter`},
+		{expression: "len(1) > 2", wantErr: `expected a column in filter, got "1"`},
+		{expression: "== 1", wantErr: `expected a column, number or string in filter, got "=="`},
+	}
+
+	for _, tt := range tests {
+		filter, err := compileFilter(tt.expression, header)
+		if tt.wantErr != "" {
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("compileFilter(%q) error = %v, want %q", tt.expression, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("compileFilter(%q) error: %v", tt.expression, err)
+			continue
+		}
+		if got := filter(tt.record); got != tt.want {
+			t.Errorf("filter %q on %v = %v, want %v", tt.expression, tt.record, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,2426 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgconn"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/denisb0/import_embeddings/models"
+)
+
+func runImport(ctx context.Context, common commonOptions, args []string) error {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	failFast := flags.Bool("fail-fast", true, "stop on the first record error; when false, errors are collected and reported at the end")
+	writeRetries := flags.Int("write-retries", 0, "number of retries for a failed write, applied to each database independently")
+	secondaryBestEffort := flags.Bool("secondary-best-effort", false, "log write failures to the secondary database instead of treating them as record errors")
+	onlyNew := flags.Bool("only-new", false, "import only records newer than the latest stored created_at of their type, requires a created_at column")
+	shuffleBuffer := flags.Int("shuffle-buffer", 0, "insert records in random order within chunks of this size, 0 keeps file order")
+	shuffleSeed := flags.Int64("shuffle-seed", 0, "seed for -shuffle-buffer, 0 picks a random seed")
+	input := flags.String("input", "embedding.csv", "path of the input file, - reads standard input, a directory imports its *.csv and *.csv.gz files as one input")
+	fileOrder := flags.String("file-order", "", "with a directory -input, file listing the names of the files to import in order, one per line, instead of all of them sorted by name")
+	onFileError := flags.String("on-file-error", fileErrorStop, "with a directory -input, what a file that can't be read does: stop the import or skip-file to go on with the next one")
+	gzipped := flags.Bool("gzip", false, "input is gzip compressed, implied by a .gz extension")
+	format := flags.String("format", "csv", "input format: csv or parquet")
+	metadataPath := flags.String("metadata", "", "CSV file with url, content and other columns for the records of a CSV -input holding only embeddings, paired by position")
+	warnPrecisionLoss := flags.Bool("warn-precision-loss", false, "log records whose values lose precision when narrowed to float32")
+	precisionThreshold := flags.Float64("precision-loss-threshold", 0, "deprecated, use the common -float-epsilon: relative error above which -warn-precision-loss reports a value, overrides -float-epsilon when given")
+	startLine := flags.Int("start-line", 0, "number of data records to skip before importing")
+	dedupReportPath := flags.String("dedup-report", "", "write records skipped because the embedding already exists to this CSV file")
+	nullContent := flags.String("null-content", "", `comma separated content values stored as NULL, e.g. ",NULL,\N" where the empty item means ""; by default content is stored literally`)
+	typeFromFilename := flags.Bool("type-from-filename", false, "use the input file name without extension as the type of every record")
+	automigrate := flags.Bool("automigrate", false, "create or update the embeddings table before importing")
+	pgvector := flags.Bool("pgvector", false, "with -automigrate, also create the vector extension if it is missing")
+	columnType := flags.String("column-type", models.ColumnTypeReal, "type of the embedding column: real[] or bytea, which stores the values as little-endian float32 bytes, compact and fast to write but without similarity search in the database")
+	queryTimeout := flags.Duration("query-timeout", 0, "timeout for every database operation, e.g. 30s; 0 waits indefinitely")
+	normalizeURL := flags.Bool("normalize-url", false, "when a URL is not found, retry matching with lowercase host, no trailing slash and without -drop-query-params on both sides")
+	dropQueryParams := flags.String("drop-query-params", "utm_*,fbclid,gclid", "comma separated query parameters ignored by -normalize-url, * at the end matches a prefix")
+	unresolvedURLsPath := flags.String("unresolved-urls", "", "write URLs without a matching content entry to this file, one per line")
+	maxRuntime := flags.Duration("max-runtime", 0, "stop cleanly after this duration and exit successfully, to be resumed by the next run with -checkpoint")
+	checkFK := flags.Bool("check-fk", false, "confirm right before each insert that the resolved content entry still exists, also verifies ids from an entry_id column")
+	urlsFile := flags.String("urls-file", "", "import only records whose URL is listed in this file, one per line")
+	onConflict := flags.String("on-conflict", conflictSkip, "policy for records whose (entry_id, type) is already stored: skip, error (like -append-only) or replace-if-newer, which overwrites rows with an older created_at and needs a unique index on (entry_id, type)")
+	batchSize := flags.Int("batch-size", 1, "number of new embeddings inserted together, 1 inserts every record on its own")
+	commitInterval := flags.Duration("commit-interval", 0, "with -batch-size, also insert a partial batch every interval so records don't wait for a full batch")
+	maxMemory := flags.String("max-memory", "", "with -batch-size, soft limit like 256MiB on the estimated memory of buffered records, a batch is written early before passing it")
+	compactLogs := flags.Bool("compact-logs", false, "count skipped records by reason and log a summary every -log-every records instead of a line per record")
+	logEvery := flags.Int64("log-every", 1000, "records between summaries with -compact-logs")
+	advisoryLock := flags.Bool("advisory-lock", false, "hold a postgres advisory lock for the type from -type-from-filename, or for the whole table otherwise, and fail if another import holds it; needs a session pooler")
+	requiredColumns := flags.String("required-columns", "", "comma separated columns the input must have besides an embedding and one of url, entry_id or entry_data, which are always required; missing optional columns get defaults: empty content, type from -type-from-filename, created_at now")
+	ensureUniqueIndex := flags.Bool("ensure-unique-index", false, "create the unique index on (entry_id, type) of the embeddings tables if it is missing, so a record imported twice is stored once")
+	createPartitions := flags.Bool("create-partition", false, "create the list partition of a type the first time a record of it is written, the embeddings table must be partitioned by type")
+	expectRows := flags.Int("expect-rows", -1, "fail before importing unless the csv input, all files of a directory together, has exactly this many data rows, -1 disables the check")
+	manifestPath := flags.String("manifest", "", "export manifest to check the input's record count, dimension and checksum against before importing")
+	wideFormat := flags.Bool("wide-format", false, "read the embedding from columns dim_0 to dim_N instead of an embedding column")
+	dim := flags.Int("dim", embeddingSize, "number of values in every embedding")
+	dryRun := flags.Bool("dry-run", false, "run every check and lookup but write nothing, the summary estimates the disk space of the new rows")
+	duplicateColumns := flags.String("duplicate-columns", duplicateColumnsError, "what to do with a column name repeated in the header: error, or use the first or last of them")
+	embeddingHash := flags.Bool("embedding-hash", false, "store the hex SHA-256 of each embedding's little-endian float32 bytes in embedding_hash, the column must exist or be created with -automigrate")
+	batchIDFlag := flags.Bool("batch-id", false, "label every embedding this run writes with a batch_id generated at start and printed in the summary, for delete-batch; the column must exist or be created with -automigrate")
+	appendOnly := flags.Bool("append-only", false, "treat a record whose embedding is already stored as an error, for one-time loads of all-new files")
+	checkpoint := flags.String("checkpoint", "", "file keeping the number of handled records, read at start to resume and written when the import stops")
+	urlSource := flags.String("url-source", "json", "where content entries keep their url: json for entry_data->>'url', column for the url column")
+	statusFile := flags.String("status-file", "", "JSON file rewritten with the current counts every -status-interval, for external monitoring")
+	statusInterval := flags.Duration("status-interval", 10*time.Second, "how often -status-file is rewritten")
+	notFoundRetries := flags.Int("not-found-retries", 0, "look up a url that isn't found this many more times before skipping the record, for entries created shortly after their embeddings")
+	notFoundDelay := flags.Duration("not-found-delay", time.Second, "wait between -not-found-retries lookups")
+	deadLetter := flags.String("dead-letter", "", "CSV file receiving every failed record verbatim under the input header, to fix and import again")
+	slowQuery := flags.Duration("slow-query", 0, "log every database operation slower than this with the input line, 0 disables it")
+	createIndex := flags.Bool("create-index", false, "create the index on the content entry url when it is missing, otherwise only warn")
+	mapType := flags.String("map-type", "", "comma separated old=new pairs rewriting record types before they are stored, other types are kept")
+	explain := flags.Bool("explain", false, "print the EXPLAIN ANALYZE plan of the url lookup for the first input record and exit")
+	vectorDelimiter := flags.String("vector-delimiter", defaultVectorDelimiter, "separator of the values in the embedding column, e.g. ; or a space, which accepts any whitespace")
+	typeToTable := flags.String("type-to-table", "", "comma separated type=table or type=table:conflict-policy pairs writing those types to other tables, other types go to embeddings, the policy defaults to -on-conflict")
+	validateURL := flags.String("validate-url-format", checkOff, "check that urls are absolute before looking them up: off, warn logs bad ones and looks them up anyway, fail counts them as failed records")
+	warmup := flags.Bool("warmup", false, "open the connections and run the lookups once before importing, so the first records don't skew timings")
+	dimAutodetect := flags.Bool("dimension-autodetect", false, "take the embedding dimension from the first record instead of -dim, later records must match it")
+	createEntries := flags.Bool("create-entries", false, "joined file mode: upsert the content entry from the entry_data JSON column in the same transaction as its embedding instead of looking up the url")
+	idsOut := flags.String("ids-out", "", "append id,entry_id,type of every inserted embedding to this CSV file, for later pipeline steps")
+	resumeFromIDs := flags.String("resume-from-ids", "", "resume a partial run from its -ids-out file: records whose (entry_id, type) it lists are skipped wherever they are in the input, everything else, failures included, is attempted again; it may be the -ids-out file of this run")
+	sessionCheck := flags.String("session-check", checkWarn, "check that the database session uses UTF8 client_encoding and UTC timezone: off, warn logs a mismatch, fail aborts the import")
+	trimFields := flags.String("trim-fields", "url,type", "comma separated columns whose values are trimmed of surrounding whitespace, add content only if its padding doesn't matter, empty trims nothing")
+	csvTrimLeadingSpace := flags.Bool("csv-trim-leading-space", false, "ignore spaces before every CSV field, content included, needed for quoted fields after \", \"")
+	filter := flags.String("filter", "", "import only records matching this expression over the columns, e.g. \"len(content) > 10 && type != 'draft'\", see compileFilter in filter.go")
+	rejectContentOver := flags.Int("reject-content-over", 0, "fail records whose content is longer than this many bytes, like other invalid records, instead of storing accidental blobs; 0 accepts any size")
+	skipEmptyEmbeddings := flags.Bool("skip-empty-embeddings", false, "skip records whose embedding is empty or [] instead of failing them, vectors of the wrong size still fail")
+	dimByType := flags.String("dim-by-type", "", "comma separated type=dim pairs for types whose embeddings have another dimension than -dim, types are matched after -map-type")
+	haltOnDrift := flags.Bool("halt-on-dimension-drift", false, "abort as soon as a record's dimension differs from the first record's, even without -fail-fast, unless -dim-by-type gives its type that dimension")
+	orphanMode := flags.Bool("orphan-mode", false, "insert records whose url has no content entry with a NULL entry_id instead of skipping them, the column must be nullable; a rerun inserts them again")
+	orphanTypeSuffix := flags.String("orphan-type-suffix", "_orphan", "with -orphan-mode, appended to the type of orphan embeddings to mark them")
+	orphansOut := flags.String("orphans-out", "", "with -orphan-mode, CSV file receiving id,url,type of every orphan embedding, for the job attaching them later")
+	updateMetadataOnly := flags.Bool("update-metadata-only", false, "update content of the stored embeddings matching (entry_id, type) without writing the vector, -map-type old=new also relabels their type; nothing is inserted")
+	embeddingColumns := flags.String("embedding-columns", "", "comma separated column=suffix pairs for rows with several embeddings, e.g. title_embedding=_title,body_embedding=_body: each column is imported as its own embedding whose type is the record type plus the suffix, before -map-type")
+	trailerDelimiter := flags.String("embedding-trailer-delimiter", "", "the embedding field may end with metadata after the vector, e.g. [1,2,3]|model=ada2 with |, cut off before the vector is parsed; it must differ from -vector-delimiter")
+	trailerFields := flags.String("embedding-trailer-fields", "", "comma separated key=column pairs storing trailer values in columns, e.g. model=type, missing columns are added; other keys are ignored")
+	embeddingJSON := flags.Bool("embedding-json", false, "decode the embedding column as a JSON array of numbers, also when it is JSON-quoted again like \"[1.0, 2.0]\", instead of splitting it on -vector-delimiter")
+	lengthPrefixed := flags.Bool("length-prefixed", false, "embeddings are written as count:v1,v2,... where count must equal the dimension and the number of values")
+	reportThroughput := flags.Duration("report-throughput", 0, "log records per second over the last interval and overall every interval, e.g. 10s, 0 disables it")
+	postSample := flags.Int("post-sample", 0, "after a successful import, read back this many random embeddings it inserted and print their entry_id, type, start of content and first values; not printed with -quiet")
+	quiet := flags.Bool("quiet", false, "don't print a line per processed record or batch, nor the -report-throughput lines, the final summary is still printed")
+	preserveOrder := flags.Bool("preserve-order", false, "with several -workers, write records in input order: parsing and lookups stay concurrent but writes run one at a time, so throughput drops to about that of a single writer")
+	workers := flags.String("workers", "1", "number of records processed concurrently, auto picks it from the CPUs and the DB_MAX_OPEN_CONNS limits")
+	_ = flags.Parse(args)
+
+	if *shuffleSeed == 0 {
+		*shuffleSeed = time.Now().UnixNano()
+	}
+
+	slowQueryThreshold = *slowQuery
+
+	urlColumn, ok := urlSources[*urlSource]
+	if !ok {
+		return fmt.Errorf("unsupported url source: %s", *urlSource)
+	}
+
+	typeMap, err := parseTypeMap(*mapType)
+	if err != nil {
+		return err
+	}
+
+	if *orphanMode && *updateMetadataOnly {
+		return errors.New("-orphan-mode can't be combined with -update-metadata-only")
+	}
+
+	multiEmbeddings, err := parseEmbeddingColumns(*embeddingColumns)
+	if err != nil {
+		return err
+	}
+	if multiEmbeddings != nil && (*wideFormat || *metadataPath != "") {
+		return errors.New("-embedding-columns can't be combined with -wide-format or -metadata")
+	}
+
+	trailerColumns, err := parseTrailerFields(*trailerFields)
+	if err != nil {
+		return err
+	}
+	if *trailerDelimiter != "" {
+		if *trailerDelimiter == *vectorDelimiter {
+			return errors.New("-embedding-trailer-delimiter must differ from -vector-delimiter")
+		}
+		if trailerColumns == nil {
+			return errors.New("-embedding-trailer-delimiter needs -embedding-trailer-fields")
+		}
+	} else if trailerColumns != nil {
+		return errors.New("-embedding-trailer-fields needs -embedding-trailer-delimiter")
+	}
+
+	if *embeddingJSON && (*lengthPrefixed || *wideFormat) {
+		return errors.New("-embedding-json can't be combined with -length-prefixed or -wide-format")
+	}
+
+	if *updateMetadataOnly && *typeToTable != "" {
+		return errors.New("-update-metadata-only can't be combined with -type-to-table")
+	}
+
+	dimensions, err := parseDimByType(*dimByType)
+	if err != nil {
+		return err
+	}
+	if dimensions != nil && *wideFormat {
+		return errors.New("-dim-by-type can't be used with -wide-format, the dim_N columns fix the dimension")
+	}
+
+	switch *validateURL {
+	case checkOff, checkWarn, checkFail:
+	default:
+		return fmt.Errorf("unknown url format check %q", *validateURL)
+	}
+
+	switch *sessionCheck {
+	case checkOff, checkWarn, checkFail:
+	default:
+		return fmt.Errorf("unknown session check %q", *sessionCheck)
+	}
+
+	var maxMemoryBytes int64
+	if *maxMemory != "" {
+		if maxMemoryBytes, err = parseByteSize(*maxMemory); err != nil {
+			return fmt.Errorf("-max-memory: %w", err)
+		}
+	}
+
+	tolerance := common.FloatTolerance
+	flags.Visit(func(f *flag.Flag) {
+		if f.Name == "precision-loss-threshold" {
+			tolerance = floatTolerance{Epsilon: *precisionThreshold}
+		}
+	})
+
+	if *statusFile != "" && *statusInterval <= 0 {
+		return errors.New("-status-interval must be positive")
+	}
+
+	if *checkpoint != "" {
+		position, ok, err := readCheckpoint(*checkpoint)
+		if err != nil {
+			return fmt.Errorf("checkpoint read error: %w", err)
+		}
+		if ok {
+			log.Println("resuming from checkpoint, skipping records ", position)
+			*startLine = int(position)
+		}
+	}
+
+	var previouslyInserted map[insertedKey]struct{}
+	if *resumeFromIDs != "" {
+		if previouslyInserted, err = readInsertedIDs(*resumeFromIDs); err != nil {
+			return fmt.Errorf("-resume-from-ids: %w", err)
+		}
+		log.Println("resuming from ids file, embeddings already inserted ", len(previouslyInserted))
+	}
+
+	var batchID uuid.UUID
+	if *batchIDFlag {
+		batchID = uuid.New()
+	}
+
+	if *maxRuntime > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *maxRuntime)
+		defer cancel()
+	}
+
+	switch *duplicateColumns {
+	case duplicateColumnsError, duplicateColumnsFirst, duplicateColumnsLast:
+	default:
+		return fmt.Errorf("unknown duplicate columns policy %q", *duplicateColumns)
+	}
+
+	switch *columnType {
+	case models.ColumnTypeReal:
+	case models.ColumnTypeBytea:
+		if *pgvector {
+			return errors.New("-pgvector can't be combined with -column-type bytea")
+		}
+	default:
+		return fmt.Errorf("unknown column type %q", *columnType)
+	}
+
+	switch *onFileError {
+	case fileErrorStop, fileErrorSkip:
+	default:
+		return fmt.Errorf("unknown file error policy %q", *onFileError)
+	}
+
+	var inputFiles []string
+	if info, err := os.Stat(*input); err == nil && info.IsDir() {
+		if *format != "csv" || *metadataPath != "" || *manifestPath != "" || *typeFromFilename {
+			return errors.New("a directory -input can't be combined with -metadata, -manifest, -type-from-filename or a -format other than csv")
+		}
+		if inputFiles, err = listInputFiles(*input, *fileOrder); err != nil {
+			return err
+		}
+		log.Println("importing files ", len(inputFiles))
+	} else if *fileOrder != "" {
+		return errors.New("-file-order requires a directory -input")
+	}
+
+	if *manifestPath != "" {
+		if *input == stdinPath || *format != "csv" {
+			return errors.New("-manifest requires a csv input file")
+		}
+
+		manifest, err := readManifest(*manifestPath)
+		if err != nil {
+			return fmt.Errorf("manifest read error: %w", err)
+		}
+		if err := checkManifest(*input, *gzipped, *duplicateColumns, manifest); err != nil {
+			return err
+		}
+		log.Println("input matches manifest, records ", manifest.Rows)
+	}
+
+	if *expectRows >= 0 {
+		if *input == stdinPath || *format != "csv" {
+			return errors.New("-expect-rows requires a csv input file or directory")
+		}
+
+		paths := inputFiles
+		if paths == nil {
+			paths = []string{*input}
+		}
+
+		var rows int
+		for _, path := range paths {
+			count, err := countRecords(path, *gzipped && inputFiles == nil)
+			if err != nil {
+				return err
+			}
+			rows += count
+		}
+		if rows != *expectRows {
+			return fmt.Errorf("input has %d data rows, expected %d", rows, *expectRows)
+		}
+		log.Println("input has the expected number of rows ", rows)
+	}
+
+	var embeddingType string
+	if *typeFromFilename {
+		if *input == stdinPath {
+			return errors.New("-type-from-filename can't be used with standard input")
+		}
+		base := strings.TrimSuffix(filepath.Base(*input), ".gz")
+		embeddingType = strings.TrimSuffix(base, filepath.Ext(base))
+		log.Println("type from file name ", embeddingType)
+	}
+
+	db, err := getDBConn(common)
+	if err != nil {
+		return err
+	}
+
+	secondaryDB, err := getSecondaryDBConn(common)
+	if err != nil {
+		return err
+	}
+
+	if *columnType == models.ColumnTypeBytea {
+		// embedding values are packed as they are written, see models.Float32Array.GormValue
+		db = db.Set(models.ColumnTypeSetting, models.ColumnTypeBytea).Session(&gorm.Session{})
+		if secondaryDB != nil {
+			secondaryDB = secondaryDB.Set(models.ColumnTypeSetting, models.ColumnTypeBytea).Session(&gorm.Session{})
+		}
+	}
+
+	if *sessionCheck != checkOff {
+		for _, conn := range []struct {
+			name string
+			db   *gorm.DB
+		}{{"database", db}, {"secondary database", secondaryDB}} {
+			if conn.db == nil {
+				continue
+			}
+			problems, err := sessionSettingProblems(conn.db)
+			if err != nil {
+				return fmt.Errorf("%s: %w", conn.name, err)
+			}
+			if len(problems) > 0 && *sessionCheck == checkFail {
+				return fmt.Errorf("%s: %s", conn.name, strings.Join(problems, ", "))
+			}
+			for _, problem := range problems {
+				log.Printf("warning: %s: %s", conn.name, problem)
+			}
+		}
+	}
+
+	if *dryRun {
+		log.Println("dry run, nothing is written to the database")
+		if *automigrate {
+			log.Println("dry run, skipping migration")
+			*automigrate = false
+		}
+		if *ensureUniqueIndex {
+			log.Println("dry run, not creating unique indexes")
+			*ensureUniqueIndex = false
+		}
+		// a dry run leaves the resume position where it was
+		*checkpoint = ""
+	}
+
+	if *automigrate {
+		if err := migrate(db, *pgvector, *columnType); err != nil {
+			return err
+		}
+
+		if secondaryDB != nil {
+			if err := migrate(secondaryDB, *pgvector, *columnType); err != nil {
+				return fmt.Errorf("secondary database: %w", err)
+			}
+		}
+	}
+
+	indexed, err := hasURLIndex(db, urlColumn)
+	if err != nil {
+		return fmt.Errorf("url index query error: %w", err)
+	}
+	if !indexed && *createIndex {
+		log.Println("creating index on content entry url, this can take a while")
+		if err := createURLIndex(db, urlColumn); err != nil {
+			return fmt.Errorf("create url index error: %w", err)
+		}
+	} else if !indexed {
+		log.Printf("warning: no index on content entry %s, url lookups scan the whole table, use -create-index to create it", urlColumn)
+	}
+
+	if *warmup {
+		start := time.Now()
+		if err := warmUp(ctx, db, urlColumn); err != nil {
+			return fmt.Errorf("warmup error: %w", err)
+		}
+		if secondaryDB != nil {
+			if err := warmUp(ctx, secondaryDB, urlColumn); err != nil {
+				return fmt.Errorf("secondary database: warmup error: %w", err)
+			}
+		}
+		log.Println("warmup done in ", time.Since(start))
+	}
+
+	if *advisoryLock {
+		name := "import " + models.Embeddings{}.TableName()
+		if embeddingType != "" {
+			name += " " + embeddingType
+		}
+
+		release, err := acquireImportLock(ctx, db, name)
+		if err != nil {
+			return err
+		}
+		defer release()
+	}
+
+	if *appendOnly {
+		if *onConflict != conflictSkip {
+			return errors.New("-append-only can't be combined with -on-conflict")
+		}
+		*onConflict = conflictError
+	}
+
+	route, err := prepareRoute(db, secondaryDB, tableRoute{Table: models.Embeddings{}.TableName(), ConflictPolicy: *onConflict}, *createPartitions, *ensureUniqueIndex)
+	if err != nil {
+		return err
+	}
+
+	routes, err := parseTypeRoutes(*typeToTable, *onConflict)
+	if err != nil {
+		return err
+	}
+	for embeddingType, r := range routes {
+		if routes[embeddingType], err = prepareRoute(db, secondaryDB, r, *createPartitions, *ensureUniqueIndex); err != nil {
+			return fmt.Errorf("type %s: %w", embeddingType, err)
+		}
+	}
+
+	for _, table := range routeTables(route.Table, routes, "") {
+		if err := checkEntryForeignKey(db, table); err != nil {
+			return err
+		}
+		if secondaryDB != nil {
+			if err := checkEntryForeignKey(secondaryDB, table); err != nil {
+				return fmt.Errorf("secondary database: %w", err)
+			}
+		}
+	}
+
+	if *orphanMode {
+		for _, table := range routeTables(route.Table, routes, "") {
+			for _, conn := range []*gorm.DB{db, secondaryDB} {
+				if conn == nil {
+					continue
+				}
+				nullable, err := columnNullable(conn, table, "entry_id")
+				if err != nil {
+					return fmt.Errorf("entry_id nullability query error: %w", err)
+				}
+				if !nullable {
+					return fmt.Errorf("-orphan-mode needs a nullable %s.entry_id, e.g. ALTER TABLE %s ALTER COLUMN entry_id DROP NOT NULL", table, table)
+				}
+				ok, err := hasColumn(conn, table, "orphan_url")
+				if err != nil {
+					return fmt.Errorf("orphan_url column query error: %w", err)
+				}
+				if !ok {
+					return fmt.Errorf("-orphan-mode needs %s.orphan_url to keep the url for reprocess-orphans, create it with -automigrate or ALTER TABLE %s ADD COLUMN orphan_url text", table, table)
+				}
+			}
+		}
+	}
+
+	if *deadLetter != "" && *format != "csv" {
+		return errors.New("-dead-letter requires csv input")
+	}
+
+	var workerCount int
+	if *workers == "auto" {
+		var limits []int
+		for _, conn := range []*gorm.DB{db, secondaryDB} {
+			if conn == nil {
+				continue
+			}
+			limit, err := maxOpenConns(conn)
+			if err != nil {
+				return err
+			}
+			limits = append(limits, limit)
+		}
+		workerCount = autoWorkers(limits...)
+		log.Printf("workers: auto picked %d, GOMAXPROCS %d, max open connections %v", workerCount, runtime.GOMAXPROCS(0), limits)
+	} else if workerCount, err = strconv.Atoi(*workers); err != nil || workerCount < 1 {
+		return fmt.Errorf("invalid -workers %q, expected a positive number or auto", *workers)
+	}
+
+	var f *inputFile
+	if inputFiles == nil {
+		if f, err = openInput(*input, *gzipped); err != nil {
+			return err
+		}
+
+		defer func() {
+			if err := f.Close(); err != nil {
+				log.Println("error closing file", err)
+			}
+		}()
+	}
+
+	var dedupReport *reportWriter
+	if *dedupReportPath != "" {
+		if dedupReport, err = newReportWriter(*dedupReportPath); err != nil {
+			return err
+		}
+
+		defer func() {
+			if err := dedupReport.Close(); err != nil {
+				log.Println("error closing dedup report", err)
+			}
+		}()
+	}
+
+	var unresolvedURLs *urlListWriter
+	if *unresolvedURLsPath != "" {
+		if unresolvedURLs, err = newURLListWriter(*unresolvedURLsPath); err != nil {
+			return err
+		}
+
+		defer func() {
+			if err := unresolvedURLs.Close(); err != nil {
+				log.Println("error closing unresolved urls file", err)
+			}
+		}()
+	}
+
+	var allowedURLs map[string]struct{}
+	if *urlsFile != "" {
+		if allowedURLs, err = readURLList(*urlsFile); err != nil {
+			return fmt.Errorf("urls file read error: %w", err)
+		}
+		log.Println("importing only listed urls ", len(allowedURLs))
+	}
+
+	var normalizer *urlNormalizer
+	if *normalizeURL {
+		normalizer = newURLNormalizer(*dropQueryParams)
+	}
+
+	var compactLogsEvery int64
+	if *compactLogs {
+		compactLogsEvery = *logEvery
+	}
+
+	var source RecordSource
+	var files *multiFileSource
+	if inputFiles != nil {
+		if files, err = newMultiFileSource(inputFiles, *onFileError, *duplicateColumns, *csvTrimLeadingSpace, multiEmbeddings); err != nil {
+			return err
+		}
+
+		defer func() {
+			if err := files.Close(); err != nil {
+				log.Println("error closing file", err)
+			}
+		}()
+
+		source = files
+	} else if *metadataPath != "" {
+		if *format != "csv" {
+			return errors.New("-metadata requires csv input")
+		}
+
+		// standard input can only be read once, its count is checked while importing instead
+		if *input != stdinPath {
+			embeddingRecords, err := countRecords(*input, *gzipped)
+			if err != nil {
+				return err
+			}
+			metadataRecords, err := countRecords(*metadataPath, false)
+			if err != nil {
+				return err
+			}
+			if embeddingRecords != metadataRecords {
+				return fmt.Errorf("row count mismatch: embeddings file has %d records, metadata file has %d", embeddingRecords, metadataRecords)
+			}
+		}
+
+		metadata, err := openInput(*metadataPath, false)
+		if err != nil {
+			return err
+		}
+
+		defer func() {
+			if err := metadata.Close(); err != nil {
+				log.Println("error closing metadata file", err)
+			}
+		}()
+
+		if source, err = newSidecarRecordSource(f, metadata, *duplicateColumns, *csvTrimLeadingSpace); err != nil {
+			return err
+		}
+	} else if source, err = openRecordSource(f.Reader, *format, *duplicateColumns, *csvTrimLeadingSpace, multiEmbeddings); err != nil {
+		return err
+	}
+
+	if trailerColumns != nil {
+		columns := make([]string, 0, len(trailerColumns))
+		for _, column := range trailerColumns {
+			columns = append(columns, column)
+		}
+		sort.Strings(columns)
+		if source, err = newExtraColumnsSource(source, columns, *duplicateColumns); err != nil {
+			return err
+		}
+	}
+
+	if *explain {
+		return explainLookup(db, source, urlColumn)
+	}
+
+	if err := dump(ctx, source, db, importOptions{
+		StartFromLine:       *startLine,
+		FailFast:            *failFast,
+		WriteRetries:        *writeRetries,
+		SecondaryDB:         secondaryDB,
+		SecondaryBestEffort: *secondaryBestEffort,
+		OnlyNew:             *onlyNew,
+		ShuffleBuffer:       *shuffleBuffer,
+		ShuffleSeed:         *shuffleSeed,
+		WarnPrecisionLoss:   *warnPrecisionLoss,
+		FloatTolerance:      tolerance,
+		DedupReport:         dedupReport,
+		NullContent:         parseNullValues(*nullContent),
+		Type:                embeddingType,
+		QueryTimeout:        *queryTimeout,
+		URLNormalizer:       normalizer,
+		UnresolvedURLs:      unresolvedURLs,
+		Checkpoint:          *checkpoint,
+		CheckFK:             *checkFK,
+		AllowedURLs:         allowedURLs,
+		BatchSize:           *batchSize,
+		CommitInterval:      *commitInterval,
+		MaxMemory:           maxMemoryBytes,
+		CompactLogsEvery:    compactLogsEvery,
+		RequiredColumns:     splitList(*requiredColumns),
+		Route:               route,
+		Routes:              routes,
+		CreatePartitions:    *createPartitions,
+		WideFormat:          *wideFormat,
+		Dimension:           *dim,
+		DryRun:              *dryRun,
+		EmbeddingHash:       *embeddingHash,
+		BatchID:             batchID,
+		Workers:             workerCount,
+		PreserveOrder:       *preserveOrder,
+		SkipEmptyEmbeddings: *skipEmptyEmbeddings,
+		RejectContentOver:   *rejectContentOver,
+		PostSample:          *postSample,
+		DimByType:           dimensions,
+		HaltOnDrift:         *haltOnDrift,
+		ReportThroughput:    *reportThroughput,
+		LengthPrefixed:      *lengthPrefixed,
+		EmbeddingJSON:       *embeddingJSON,
+		TrailerDelimiter:    *trailerDelimiter,
+		TrailerColumns:      trailerColumns,
+		UpdateMetadataOnly:  *updateMetadataOnly,
+		OrphanMode:          *orphanMode,
+		OrphanTypeSuffix:    *orphanTypeSuffix,
+		OrphansOut:          *orphansOut,
+		Quiet:               *quiet,
+		URLColumn:           urlColumn,
+		StatusFile:          *statusFile,
+		StatusInterval:      *statusInterval,
+		NotFoundRetries:     *notFoundRetries,
+		NotFoundDelay:       *notFoundDelay,
+		DeadLetter:          *deadLetter,
+		TypeMap:             typeMap,
+		VectorDelimiter:     *vectorDelimiter,
+		URLCheck:            *validateURL,
+		DimensionAutodetect: *dimAutodetect,
+		CreateEntries:       *createEntries,
+		Filter:              *filter,
+		IDsOut:              *idsOut,
+		PreviouslyInserted:  previouslyInserted,
+		TrimFields:          splitList(*trimFields),
+	}); err != nil {
+		return err
+	}
+
+	if files != nil {
+		fmt.Println("files imported ", len(files.Imported), " of ", len(inputFiles))
+		for _, path := range files.Skipped {
+			fmt.Println("skipped file ", path)
+		}
+	}
+
+	fmt.Println("processing complete")
+
+	return nil
+}
+
+// explainLookup prints the plan of the url lookup for the first record, to tell an index scan from a sequential scan
+func explainLookup(db *gorm.DB, source RecordSource, urlColumn string) error {
+	cols := source.Columns()
+	if cols.URL < 0 {
+		return errors.New("-explain requires a url column")
+	}
+
+	rec, err := source.Next()
+	if err != nil {
+		return fmt.Errorf("unable to read record %w", err)
+	}
+
+	plan, err := explainFindEntryByURL(db, urlColumn, cols.url(rec.Fields))
+	if err != nil {
+		return fmt.Errorf("explain query error: %w", err)
+	}
+
+	fmt.Println("url lookup plan for", cols.url(rec.Fields))
+	for _, line := range plan {
+		fmt.Println(line)
+	}
+
+	return nil
+}
+
+// trimTrailingSeparator drops the empty last element left by a trailing separator, e.g. "[1, 2, ]",
+// and reports whether it did
+func trimTrailingSeparator(strEmbedding string) (string, bool) {
+	inner := strings.TrimRight(strings.Trim(strEmbedding, "[]"), " ")
+	if !strings.HasSuffix(inner, ",") {
+		return strEmbedding, false
+	}
+
+	return "[" + strings.TrimRight(strings.TrimSuffix(inner, ","), " ") + "]", true
+}
+
+// cutLengthPrefix checks an embedding written as count:v1,v2,... and returns the values after the colon.
+// The declared count must be dim and match the number of values.
+func cutLengthPrefix(strEmbedding string, dim int, delimiter string) (string, error) {
+	prefix, values, ok := strings.Cut(strings.TrimSpace(strEmbedding), ":")
+	if !ok {
+		return "", errors.New("embedding has no length prefix, expected count:v1,v2,...")
+	}
+
+	declared, err := strconv.Atoi(strings.TrimSpace(prefix))
+	if err != nil {
+		return "", fmt.Errorf("invalid embedding length prefix %q", prefix)
+	}
+	if declared != dim {
+		return "", fmt.Errorf("embedding length prefix declares %d values, expected dimension %d", declared, dim)
+	}
+	if count := len(splitEmbedding(values, delimiter)); count != declared {
+		return "", fmt.Errorf("embedding length prefix declares %d values but %d follow", declared, count)
+	}
+
+	return values, nil
+}
+
+// defaultVectorDelimiter separates embedding values unless -vector-delimiter says otherwise
+const defaultVectorDelimiter = ","
+
+// splitEmbedding drops the brackets and splits the values on delimiter, values are trimmed so "1, 2" and "1,2" are
+// the same. A space delimiter accepts any run of whitespace.
+func splitEmbedding(strEmbedding, delimiter string) []string {
+	strEmbedding = strings.Trim(strings.TrimSpace(strEmbedding), "[]")
+	if strings.TrimSpace(delimiter) == "" {
+		return strings.Fields(strEmbedding)
+	}
+
+	strValues := strings.Split(strEmbedding, delimiter)
+	for i := range strValues {
+		strValues[i] = strings.TrimSpace(strValues[i])
+	}
+
+	return strValues
+}
+
+func convertEmbedding(strEmbedding, delimiter string, vectorBuffer []float32) error {
+	strValues := splitEmbedding(strEmbedding, delimiter)
+
+	if len(strValues) != len(vectorBuffer) {
+		return fmt.Errorf("vector size not equal embedding values size: %d", len(strValues))
+	}
+
+	for i, strValue := range strValues {
+		value, err := parseFloat32(strValue, i)
+		if err != nil {
+			return err
+		}
+
+		vectorBuffer[i] = value
+	}
+
+	return nil
+}
+
+// parseFloat32 parses an embedding value, one whose magnitude is beyond math.MaxFloat32 fails instead of being stored
+// as an infinity: strconv reports it as out of range, and so is an explicit inf
+func parseFloat32(strValue string, position int) (float32, error) {
+	value, err := strconv.ParseFloat(strValue, 32)
+	if err != nil && !errors.Is(err, strconv.ErrRange) {
+		return 0, fmt.Errorf("error parsing value: %v, position %d", err, position)
+	}
+	if math.Abs(value) > math.MaxFloat32 {
+		return 0, fmt.Errorf("value %q overflows float32, position %d", strValue, position)
+	}
+
+	return float32(value), nil
+}
+
+// decodeJSONEmbedding decodes a JSON array of numbers, narrowed to float32. A JSON string holding the array, left by
+// exporters that quote the column twice, is decoded once more. An empty field is an empty vector.
+func decodeJSONEmbedding(strEmbedding string) ([]float32, error) {
+	data := []byte(strings.TrimSpace(strEmbedding))
+	if len(data) == 0 {
+		return []float32{}, nil
+	}
+
+	var quoted string
+	if err := json.Unmarshal(data, &quoted); err == nil {
+		data = []byte(strings.TrimSpace(quoted))
+	}
+
+	var values []float64
+	if err := json.Unmarshal(data, &values); err != nil {
+		return nil, fmt.Errorf("invalid JSON embedding: %w", err)
+	}
+
+	vector := make([]float32, len(values))
+	for i, v := range values {
+		if math.Abs(v) > math.MaxFloat32 {
+			return nil, fmt.Errorf("value %g overflows float32, position %d", v, i)
+		}
+		vector[i] = float32(v)
+	}
+
+	return vector, nil
+}
+
+// hashEmbedding returns the hex SHA-256 of the vector as little-endian float32 bytes, the same vector stored
+// anywhere gets the same hash
+func hashEmbedding(vector []float32) string {
+	sum := sha256.Sum256(models.Float32Array(vector).Bytes())
+	return hex.EncodeToString(sum[:])
+}
+
+// convertWideEmbedding parses one value per column, dims holds the column of every dimension
+func convertWideEmbedding(record []string, dims []int, vectorBuffer []float32) error {
+	if len(dims) != len(vectorBuffer) {
+		return fmt.Errorf("vector size not equal embedding values size: %d", len(dims))
+	}
+
+	for i, pos := range dims {
+		value, err := parseFloat32(strings.TrimSpace(record[pos]), i)
+		if err != nil {
+			return err
+		}
+
+		vectorBuffer[i] = value
+	}
+
+	return nil
+}
+
+var createdAtLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.999999999Z07",
+	"2006-01-02 15:04:05.999999999",
+}
+
+// recordCreatedAt returns created_at from the record if the file carries it, otherwise the fallback
+func recordCreatedAt(record []string, cols recordColumns, fallback time.Time) (time.Time, error) {
+	if cols.CreatedAt < 0 {
+		return fallback, nil
+	}
+
+	value := record[cols.CreatedAt]
+	for _, layout := range createdAtLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t.UTC(), nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("unable to parse created_at: %q", value)
+}
+
+// parseNullValues splits a comma separated list of content values stored as NULL, an empty item stands for ""
+func parseNullValues(list string) map[string]bool {
+	if list == "" {
+		return nil
+	}
+
+	values := make(map[string]bool)
+	for _, v := range strings.Split(list, ",") {
+		values[v] = true
+	}
+
+	return values
+}
+
+// splitList splits a comma separated flag value, blank items are dropped
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+
+	return items
+}
+
+// parseTypeMap parses old=new pairs separated by commas
+func parseTypeMap(list string) (map[string]string, error) {
+	items := splitList(list)
+	if len(items) == 0 {
+		return nil, nil
+	}
+
+	types := make(map[string]string, len(items))
+	for _, item := range items {
+		from, to, ok := strings.Cut(item, "=")
+		from, to = strings.TrimSpace(from), strings.TrimSpace(to)
+		if !ok || from == "" || to == "" {
+			return nil, fmt.Errorf("invalid type mapping %q, expected old=new", item)
+		}
+		types[from] = to
+	}
+
+	return types, nil
+}
+
+// parseEmbeddingColumns parses column=suffix pairs separated by commas, suffixes must differ so the embeddings of a
+// row get distinct types
+func parseEmbeddingColumns(list string) ([]embeddingColumn, error) {
+	var columns []embeddingColumn
+	suffixes := make(map[string]bool)
+	for _, item := range splitList(list) {
+		column, suffix, ok := strings.Cut(item, "=")
+		column, suffix = strings.TrimSpace(column), strings.TrimSpace(suffix)
+		if !ok || column == "" {
+			return nil, fmt.Errorf("invalid embedding column %q, expected column=suffix", item)
+		}
+		if suffixes[suffix] {
+			return nil, fmt.Errorf("embedding columns share the type suffix %q", suffix)
+		}
+		suffixes[suffix] = true
+		columns = append(columns, embeddingColumn{Column: column, TypeSuffix: suffix})
+	}
+
+	return columns, nil
+}
+
+// parseTrailerFields parses key=column pairs separated by commas
+func parseTrailerFields(list string) (map[string]string, error) {
+	items := splitList(list)
+	if len(items) == 0 {
+		return nil, nil
+	}
+
+	fields := make(map[string]string, len(items))
+	for _, item := range items {
+		key, column, ok := strings.Cut(item, "=")
+		key, column = strings.TrimSpace(key), strings.TrimSpace(column)
+		if !ok || key == "" || column == "" {
+			return nil, fmt.Errorf("invalid embedding trailer field %q, expected key=column", item)
+		}
+		fields[key] = column
+	}
+
+	return fields, nil
+}
+
+// embeddingTrailer cuts metadata off the end of the embedding field, like |model=ada2|lang=en after [1,2,3]: the
+// trailer starts at the first delimiter after the closing bracket, or after the start without brackets, and holds
+// key=value items separated by the delimiter
+type embeddingTrailer struct {
+	delimiter string
+	positions map[string]int // trailer key to the column receiving its value
+}
+
+func newEmbeddingTrailer(delimiter string, columns map[string]string, cols recordColumns) *embeddingTrailer {
+	t := &embeddingTrailer{delimiter: delimiter, positions: make(map[string]int, len(columns))}
+	for key, column := range columns {
+		// newExtraColumnsSource added the missing columns, the last one wins like in parseHeader
+		for i, name := range cols.Header {
+			if name == column {
+				t.positions[key] = i
+			}
+		}
+	}
+
+	return t
+}
+
+// split leaves the vector in the embedding field and stores the trailer values in their columns, a field without
+// trailer is kept as it is
+func (t *embeddingTrailer) split(record []string, embeddingColumn int) error {
+	field := record[embeddingColumn]
+	start := strings.LastIndex(field, "]") + 1
+	i := strings.Index(field[start:], t.delimiter)
+	if i < 0 {
+		return nil
+	}
+
+	record[embeddingColumn] = strings.TrimSpace(field[:start+i])
+	for _, item := range strings.Split(field[start+i+len(t.delimiter):], t.delimiter) {
+		if strings.TrimSpace(item) == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(item, "=")
+		if !ok {
+			return fmt.Errorf("trailer item %q is not key=value", item)
+		}
+		if pos, ok := t.positions[strings.TrimSpace(key)]; ok {
+			record[pos] = strings.TrimSpace(value)
+		}
+	}
+
+	return nil
+}
+
+// dimensionDrift remembers the dimension of the first record to catch inputs that mix embedding models,
+// it is safe for concurrent use
+type dimensionDrift struct {
+	mu        sync.Mutex
+	seen      bool
+	dimension int
+	line      int
+}
+
+// check fails if dimension differs from the first checked record's. With typeDim the record's type has its own
+// dimension dim, which explains a difference as long as the record matches it.
+func (d *dimensionDrift) check(dimension, line int, typeDim bool, dim int) error {
+	if typeDim {
+		if dimension != dim {
+			return fmt.Errorf("line %d: embedding dimension %d differs from %d given by -dim-by-type for its type, halting on dimension drift", line, dimension, dim)
+		}
+		return nil
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if !d.seen {
+		d.seen, d.dimension, d.line = true, dimension, line
+		return nil
+	}
+	if dimension != d.dimension {
+		return fmt.Errorf("line %d: embedding dimension drifted from %d, first seen on line %d, to %d, halting on dimension drift", line, d.dimension, d.line, dimension)
+	}
+
+	return nil
+}
+
+// parseDimByType parses type=dim pairs separated by commas
+func parseDimByType(list string) (map[string]int, error) {
+	items := splitList(list)
+	if len(items) == 0 {
+		return nil, nil
+	}
+
+	dimensions := make(map[string]int, len(items))
+	for _, item := range items {
+		embeddingType, value, ok := strings.Cut(item, "=")
+		embeddingType = strings.TrimSpace(embeddingType)
+		dim, err := strconv.Atoi(strings.TrimSpace(value))
+		if !ok || embeddingType == "" || err != nil || dim < 1 {
+			return nil, fmt.Errorf("invalid type dimension %q, expected type=dim", item)
+		}
+		dimensions[embeddingType] = dim
+	}
+
+	return dimensions, nil
+}
+
+// joinedEntry is the content entry of a record from a joined file, created along with its embedding
+type joinedEntry struct {
+	ID        uuid.UUID
+	EntryData string // JSON as found in the file
+}
+
+// recordEntry reads the content entry of a joined file record. Its id comes from the entry_id column, or is
+// derived from the url in entry_data, so importing the file again updates the same entries.
+func recordEntry(record []string, cols recordColumns) (*joinedEntry, error) {
+	entryData := record[cols.EntryData]
+	var data struct {
+		URL string `json:"url"`
+	}
+	if err := json.Unmarshal([]byte(entryData), &data); err != nil {
+		return nil, fmt.Errorf("invalid entry_data: %w", err)
+	}
+
+	if cols.EntryID >= 0 {
+		id, err := uuid.Parse(record[cols.EntryID])
+		if err != nil {
+			return nil, fmt.Errorf("invalid entry_id %q: %w", record[cols.EntryID], err)
+		}
+		return &joinedEntry{ID: id, EntryData: entryData}, nil
+	}
+
+	if data.URL == "" {
+		return nil, errors.New("entry_data has no url to derive the entry id from, add an entry_id column")
+	}
+
+	return &joinedEntry{ID: uuid.NewSHA1(uuid.NameSpaceURL, []byte(data.URL)), EntryData: entryData}, nil
+}
+
+// recordDimension counts the embedding values of a record without parsing them
+func recordDimension(rec Record, cols recordColumns, wide bool, delimiter string) int {
+	switch {
+	case rec.Vector != nil:
+		return len(rec.Vector)
+	case wide || cols.Embedding < 0:
+		return len(cols.Dims)
+	}
+
+	embedding := rec.Fields[cols.Embedding]
+	if delimiter == defaultVectorDelimiter {
+		embedding, _ = trimTrailingSeparator(embedding)
+	}
+
+	return len(splitEmbedding(embedding, delimiter))
+}
+
+// emptyEmbedding reports whether the record has no embedding values at all, like "" or "[]" left by a failed
+// generation, as opposed to a vector of the wrong size
+func emptyEmbedding(rec Record, cols recordColumns, wide bool) bool {
+	switch {
+	case rec.Vector != nil:
+		return len(rec.Vector) == 0
+	case wide || cols.Embedding < 0:
+		for _, pos := range cols.Dims {
+			if strings.TrimSpace(rec.Fields[pos]) != "" {
+				return false
+			}
+		}
+		return true
+	}
+
+	return strings.Trim(rec.Fields[cols.Embedding], "[] \t\r\n") == ""
+}
+
+// convertRecord builds the embedding of a record, the vector must have dim values. Without an embedding column
+// the vector is read from the dim_N columns of the wide format.
+func convertRecord(rec Record, cols recordColumns, dim int, delimiter string, now time.Time, nullContent map[string]bool) (models.Embeddings, error) {
+	record := rec.Fields
+	buf := make([]float32, dim)
+	switch {
+	case rec.Vector != nil:
+		if len(rec.Vector) != dim {
+			return models.Embeddings{}, fmt.Errorf("vector size not equal embedding values size: %d", len(rec.Vector))
+		}
+		copy(buf, rec.Vector)
+	case cols.Embedding < 0:
+		if err := convertWideEmbedding(record, cols.Dims, buf); err != nil {
+			return models.Embeddings{}, err
+		}
+	default:
+		if err := convertEmbedding(record[cols.Embedding], delimiter, buf); err != nil {
+			return models.Embeddings{}, err
+		}
+	}
+
+	createdAt, err := recordCreatedAt(record, cols, now)
+	if err != nil {
+		return models.Embeddings{}, err
+	}
+
+	var value string
+	if cols.Content >= 0 {
+		value = record[cols.Content]
+	}
+
+	var content *string
+	if !nullContent[value] {
+		content = &value
+	}
+
+	return models.Embeddings{
+		Embedding: buf,
+		Content:   content,
+		CreatedAt: createdAt,
+	}, nil
+}
+
+type importOptions struct {
+	StartFromLine       int
+	FailFast            bool     // stop on the first record error, otherwise collect errors and report them at the end
+	WriteRetries        int      // retries per write target
+	SecondaryDB         *gorm.DB // optional mirror receiving every written embedding
+	SecondaryBestEffort bool     // log secondary write failures instead of treating them as record errors
+	OnlyNew             bool     // skip records not newer than the latest stored created_at of their type
+	ShuffleBuffer       int      // insert records in random order within chunks of this size, 0 keeps file order
+	ShuffleSeed         int64
+	WarnPrecisionLoss   bool                  // log records whose values lose precision when stored as float32
+	FloatTolerance      floatTolerance        // when -warn-precision-loss reports a value
+	DedupReport         *reportWriter         // receives records skipped because the embedding already exists
+	NullContent         map[string]bool       // content values stored as NULL
+	Type                string                // type for every record, overrides the type column
+	QueryTimeout        time.Duration         // deadline for every database operation, 0 disables it
+	URLNormalizer       *urlNormalizer        // retries lookups that found nothing with normalized URLs on both sides
+	UnresolvedURLs      *urlListWriter        // receives URLs without a content entry
+	Checkpoint          string                // file receiving the number of handled records when the import stops
+	CheckFK             bool                  // confirm the content entry still exists right before insert
+	AllowedURLs         map[string]struct{}   // when not nil, records with other URLs are skipped
+	BatchSize           int                   // new embeddings inserted together, replaced ones are always written on their own
+	CommitInterval      time.Duration         // longest time a partial batch waits before it is inserted, 0 waits for a full batch
+	MaxMemory           int64                 // estimated bytes of buffered records that make a batch be inserted early, 0 is unlimited
+	CompactLogsEvery    int64                 // summarize skipped records by reason every this many records, 0 logs each one
+	RequiredColumns     []string              // columns the input header must have
+	Route               tableRoute            // destination of types missing from Routes
+	Routes              map[string]tableRoute // destinations by type
+	CreatePartitions    bool                  // create missing partitions of the types written, in every partitioned database
+	WideFormat          bool                  // read embeddings from dim_N columns
+	Dimension           int                   // number of values in every embedding
+	DryRun              bool                  // count what would be written and estimate its size instead of writing
+	EmbeddingHash       bool                  // write embedding_hash, otherwise the column is left out of writes
+	BatchID             uuid.UUID             // written to batch_id of every written row, uuid.Nil leaves the column out of writes
+	Workers             int                   // records processed concurrently, 1 processes them in input order
+	PreserveOrder       bool                  // with several workers, records are still written in input order
+	SkipEmptyEmbeddings bool                  // records without embedding values are skipped instead of failed
+	RejectContentOver   int                   // records with longer content in bytes fail, 0 accepts any size
+	PostSample          int                   // inserted embeddings read back and printed after the import
+	DimByType           map[string]int        // Dimension of the listed types
+	HaltOnDrift         bool                  // a record whose dimension differs from the first one's aborts the import
+	ReportThroughput    time.Duration         // interval of the throughput log lines, 0 disables them
+	Quiet               bool                  // no progress output per record or batch
+	LengthPrefixed      bool                  // embedding values follow a count: prefix
+	EmbeddingJSON       bool                  // the embedding column is decoded as JSON, see decodeJSONEmbedding
+	TrailerDelimiter    string                // separates the metadata trailing the vector in the embedding column
+	TrailerColumns      map[string]string     // trailer key to the column receiving its value
+	UpdateMetadataOnly  bool                  // update content and type of stored embeddings instead of inserting, TypeMap gives the new types
+	OrphanMode          bool                  // records whose url isn't found are inserted with a NULL entry_id
+	OrphanTypeSuffix    string                // marks the type of orphan embeddings
+	OrphansOut          string                // CSV file receiving id, url and type of orphan embeddings
+	URLColumn           string                // SQL expression of the content entry url, from urlSources
+	StatusFile          string                // JSON file with the current counts, rewritten every StatusInterval
+	StatusInterval      time.Duration
+	NotFoundRetries     int                      // extra lookups of a url that isn't found before the record is skipped
+	NotFoundDelay       time.Duration            // wait between those lookups
+	DeadLetter          string                   // CSV file receiving failed records in the input format
+	TypeMap             map[string]string        // record types replaced before they are used, nil keeps every type
+	VectorDelimiter     string                   // separator of the values in the embedding column
+	URLCheck            string                   // checkOff, checkWarn or checkFail for urls that aren't absolute
+	DimensionAutodetect bool                     // Dimension is taken from the first record
+	CreateEntries       bool                     // upsert content entries from the entry_data column instead of looking them up
+	Filter              string                   // expression records must match to be imported, empty imports all
+	IDsOut              string                   // CSV file the inserted embedding ids are appended to
+	PreviouslyInserted  map[insertedKey]struct{} // (entry_id, type) of embeddings a resumed run inserted, skipped
+	TrimFields          []string                 // columns whose values are trimmed of surrounding whitespace
+}
+
+// conflict policies for records whose (entry_id, type) is already stored
+const (
+	conflictSkip           = "skip"
+	conflictReplaceIfNewer = "replace-if-newer"
+	conflictError          = "error" // -append-only
+)
+
+// tableRoute is a table receiving embeddings with the conflict policy applied to it and its partitioning
+// in the primary and secondary databases
+type tableRoute struct {
+	Table                string
+	ConflictPolicy       string // what to do with records already stored: conflictSkip, conflictError or conflictReplaceIfNewer
+	Partitioned          bool   // inserts into a partitioned table can't target the id
+	SecondaryPartitioned bool
+	NaturalKey           bool // the table has a unique index on (entry_id, type), inserts target it
+	SecondaryNaturalKey  bool
+}
+
+// parseTypeRoutes parses type=table or type=table:policy pairs separated by commas, routes without a policy
+// get defaultPolicy
+func parseTypeRoutes(list, defaultPolicy string) (map[string]tableRoute, error) {
+	items := splitList(list)
+	if len(items) == 0 {
+		return nil, nil
+	}
+
+	routes := make(map[string]tableRoute, len(items))
+	for _, item := range items {
+		embeddingType, dest, ok := strings.Cut(item, "=")
+		table, policy, _ := strings.Cut(dest, ":")
+		embeddingType, table, policy = strings.TrimSpace(embeddingType), strings.TrimSpace(table), strings.TrimSpace(policy)
+		if !ok || embeddingType == "" || table == "" {
+			return nil, fmt.Errorf("invalid type route %q, expected type=table or type=table:policy", item)
+		}
+		if policy == "" {
+			policy = defaultPolicy
+		}
+		routes[embeddingType] = tableRoute{Table: table, ConflictPolicy: policy}
+	}
+
+	return routes, nil
+}
+
+// routeTables lists the distinct tables embeddings are written to, defaultTable first and the routed ones sorted.
+// With embeddingType only the table of that type is listed.
+func routeTables(defaultTable string, routes map[string]tableRoute, embeddingType string) []string {
+	if embeddingType != "" {
+		if r, ok := routes[embeddingType]; ok {
+			return []string{r.Table}
+		}
+		return []string{defaultTable}
+	}
+
+	var routed []string
+	seen := map[string]bool{defaultTable: true}
+	for _, r := range routes {
+		if !seen[r.Table] {
+			seen[r.Table] = true
+			routed = append(routed, r.Table)
+		}
+	}
+	sort.Strings(routed)
+
+	return append([]string{defaultTable}, routed...)
+}
+
+// prepareRoute checks that the route's table supports its conflict policy and -create-partition, and finds out
+// whether the table is partitioned in each database
+func prepareRoute(db, secondaryDB *gorm.DB, route tableRoute, createPartitions, ensureUniqueIndex bool) (tableRoute, error) {
+	var err error
+	if route.Partitioned, err = isPartitioned(db, route.Table); err != nil {
+		return route, fmt.Errorf("partitioning query error: %w", err)
+	}
+	if createPartitions && !route.Partitioned {
+		return route, fmt.Errorf("-create-partition requires the %s table to be partitioned by type", route.Table)
+	}
+
+	if secondaryDB != nil {
+		if route.SecondaryPartitioned, err = isPartitioned(secondaryDB, route.Table); err != nil {
+			return route, fmt.Errorf("secondary database: partitioning query error: %w", err)
+		}
+	}
+
+	for _, target := range []struct {
+		db          *gorm.DB
+		partitioned bool
+		naturalKey  *bool
+	}{{db, route.Partitioned, &route.NaturalKey}, {secondaryDB, route.SecondaryPartitioned, &route.SecondaryNaturalKey}} {
+		if target.db == nil {
+			continue
+		}
+		ok, err := hasUniqueIndex(target.db, route.Table, "entry_id", "type")
+		if err != nil {
+			return route, fmt.Errorf("unique index query error: %w", err)
+		}
+		if !ok && ensureUniqueIndex {
+			if err := createNaturalKeyIndex(target.db, route.Table, target.partitioned); err != nil {
+				return route, fmt.Errorf("create unique index on %s (entry_id, type) error, duplicates already stored must be removed first: %w", route.Table, err)
+			}
+			ok = true
+		}
+		*target.naturalKey = ok
+	}
+
+	switch route.ConflictPolicy {
+	case conflictSkip, conflictError:
+	case conflictReplaceIfNewer:
+		if !route.NaturalKey || (secondaryDB != nil && !route.SecondaryNaturalKey) {
+			return route, fmt.Errorf("replace-if-newer requires a unique index on %s (entry_id, type), -ensure-unique-index creates it", route.Table)
+		}
+	default:
+		return route, fmt.Errorf("unknown conflict policy %q", route.ConflictPolicy)
+	}
+
+	return route, nil
+}
+
+// importCounters track record outcomes, they are safe for concurrent use
+type importCounters struct {
+	read      atomic.Int64
+	inserted  atomic.Int64
+	skipped   atomic.Int64
+	failed    atomic.Int64
+	recovered atomic.Int64 // urls matched only after normalization, the record then also ends in one of the outcomes above
+	filtered  atomic.Int64 // records not in the urls allow-list, also counted as skipped
+	updated   atomic.Int64 // stored rows replaced by a newer record
+	unchanged atomic.Int64 // stored rows kept because the record was not newer, also counted as skipped
+	rejected  atomic.Int64 // records not matching -filter, also counted as skipped
+	orphaned  atomic.Int64 // records inserted without a content entry, also counted as inserted
+}
+
+// phaseTimer accumulates time spent in a phase of the import, it is safe for concurrent use
+type phaseTimer struct {
+	nanos atomic.Int64
+}
+
+// since adds the time elapsed from start, which should come from time.Now to use the monotonic clock
+func (p *phaseTimer) since(start time.Time) {
+	p.nanos.Add(int64(time.Since(start)))
+}
+
+func (p *phaseTimer) Duration() time.Duration {
+	return time.Duration(p.nanos.Load()).Round(time.Millisecond)
+}
+
+// phaseTimings break down where an import spends its time
+type phaseTimings struct {
+	read    phaseTimer // reading and parsing input records
+	lookup  phaseTimer // resolving urls to content entries
+	exists  phaseTimer // checking for stored embeddings
+	convert phaseTimer // parsing embeddings
+	insert  phaseTimer // writes to every database
+}
+
+func (t *phaseTimings) String() string {
+	return fmt.Sprintf("read: %s, lookup: %s, exists: %s, convert: %s, insert: %s",
+		t.read.Duration(), t.lookup.Duration(), t.exists.Duration(), t.convert.Duration(), t.insert.Duration())
+}
+
+// reconcile warns when outcomes don't add up to the records read, which means a counting bug or a lost record
+func (c *importCounters) reconcile() {
+	read, inserted, updated, skipped, failed := c.read.Load(), c.inserted.Load(), c.updated.Load(), c.skipped.Load(), c.failed.Load()
+	if inserted+updated+skipped+failed != read {
+		log.Printf("warning: counters don't reconcile, read %d != inserted %d + updated %d + skipped %d + failed %d", read, inserted, updated, skipped, failed)
+	}
+}
+
+// errRecordInterrupted stops the workers when a lookup is cancelled with the import, the record isn't a failure
+var errRecordInterrupted = errors.New("record interrupted")
+
+// dump imports records until the input is exhausted or ctx is cancelled. Every record or batch is committed on its own,
+// so on cancellation the record in flight is finished, the pending batch is written and the run stops with everything
+// read so far durable. Only lookups are bound to ctx, a record cancelled before its write is left to the resumed run.
+func dump(ctx context.Context, records RecordSource, db *gorm.DB, opts importOptions) (result error) {
+	// try with local db first
+	cols := records.Columns()
+
+	if opts.DimensionAutodetect {
+		// empty embeddings that will be skipped don't tell the dimension, the first other record does
+		var peeked []Record
+		for {
+			first, err := records.Next()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				return fmt.Errorf("unable to read record %w", err)
+			}
+			if opts.EmbeddingJSON && first.Vector == nil && cols.Embedding >= 0 {
+				// a record that doesn't decode fails later, when it is processed
+				if vector, err := decodeJSONEmbedding(first.Fields[cols.Embedding]); err == nil {
+					first.Vector = vector
+				}
+			}
+			peeked = append(peeked, first)
+			if opts.SkipEmptyEmbeddings && emptyEmbedding(first, cols, opts.WideFormat) {
+				continue
+			}
+			opts.Dimension = recordDimension(first, cols, opts.WideFormat, opts.VectorDelimiter)
+			log.Printf("dimension %d detected from line %d", opts.Dimension, first.Line)
+			break
+		}
+		records = &peekedSource{RecordSource: records, peeked: peeked}
+	}
+
+	if opts.WideFormat {
+		if len(cols.Dims) != opts.Dimension {
+			return fmt.Errorf("wide format input has %d dim_N columns, expected %d", len(cols.Dims), opts.Dimension)
+		}
+		// convertRecord reads the dim_N columns when there is no embedding column
+		cols.Embedding = -1
+	} else if cols.Embedding < 0 {
+		return errors.New("required column \"embedding\" not found in header, use -wide-format for dim_N columns")
+	}
+
+	if err := cols.requireColumns(opts.RequiredColumns); err != nil {
+		return err
+	}
+
+	if opts.CreateEntries && cols.EntryData < 0 {
+		return errors.New("-create-entries requires an entry_data column")
+	}
+
+	if !opts.CreateEntries && cols.URL < 0 && cols.EntryID < 0 {
+		return errors.New("an entry_data column without url or entry_id columns requires -create-entries")
+	}
+
+	if opts.AllowedURLs != nil && cols.URL < 0 {
+		return errors.New("urls file requires a url column")
+	}
+
+	if opts.OrphanMode && cols.URL < 0 {
+		return errors.New("-orphan-mode requires a url column")
+	}
+
+	if opts.UpdateMetadataOnly && cols.Content < 0 && len(opts.TypeMap) == 0 {
+		return errors.New("-update-metadata-only needs a content column or -map-type, there is nothing to update")
+	}
+
+	trim := cols.trimmer(opts.TrimFields)
+
+	var trailer *embeddingTrailer
+	if opts.TrailerDelimiter != "" {
+		if cols.Embedding < 0 {
+			return errors.New("-embedding-trailer-delimiter requires an embedding column")
+		}
+		trailer = newEmbeddingTrailer(opts.TrailerDelimiter, opts.TrailerColumns, cols)
+	}
+
+	var filter recordFilter
+	if opts.Filter != "" {
+		var err error
+		if filter, err = compileFilter(opts.Filter, cols.Header); err != nil {
+			return fmt.Errorf("invalid filter: %w", err)
+		}
+	}
+
+	if cols.Type < 0 && opts.Type == "" {
+		return errors.New("required column \"type\" not found in header")
+	}
+
+	if opts.OnlyNew && cols.CreatedAt < 0 {
+		return errors.New("only-new mode requires a created_at column")
+	}
+
+	replaceIfNewer := opts.Route.ConflictPolicy == conflictReplaceIfNewer
+	for _, r := range opts.Routes {
+		replaceIfNewer = replaceIfNewer || r.ConflictPolicy == conflictReplaceIfNewer
+	}
+
+	if replaceIfNewer && cols.CreatedAt < 0 {
+		return errors.New("replace-if-newer conflict policy requires a created_at column")
+	}
+
+	if opts.ShuffleBuffer > 0 {
+		log.Println("shuffling records, buffer ", opts.ShuffleBuffer, " seed ", opts.ShuffleSeed)
+		records = newShuffleSource(records, opts.ShuffleBuffer, opts.ShuffleSeed)
+	}
+
+	if !opts.EmbeddingHash {
+		db = withoutEmbeddingHash(db)
+		if opts.SecondaryDB != nil {
+			opts.SecondaryDB = withoutEmbeddingHash(opts.SecondaryDB)
+		}
+	}
+
+	if !opts.OrphanMode {
+		db = withoutOrphanURL(db)
+		if opts.SecondaryDB != nil {
+			opts.SecondaryDB = withoutOrphanURL(opts.SecondaryDB)
+		}
+	}
+
+	if opts.BatchID == uuid.Nil {
+		db = withoutBatchID(db)
+		if opts.SecondaryDB != nil {
+			opts.SecondaryDB = withoutBatchID(opts.SecondaryDB)
+		}
+	} else {
+		log.Println("labeling written embeddings with batch id ", opts.BatchID)
+	}
+
+	// writes, including those of the secondary database, aren't cancelled with ctx so the ones in flight and the final
+	// batch flush still commit, -query-timeout bounds them
+	writeDB := db.WithContext(context.Background())
+	db = db.WithContext(ctx)
+
+	var counters importCounters
+	var timings phaseTimings
+	if opts.StatusFile != "" {
+		status := newStatusWriter(opts.StatusFile, opts.StatusInterval, &counters)
+		defer func() {
+			switch {
+			case result != nil:
+				status.Stop(statusFailed)
+			case ctx.Err() != nil:
+				status.Stop(statusStopped)
+			default:
+				status.Stop(statusCompleted)
+			}
+		}()
+	}
+	if opts.ReportThroughput > 0 && !opts.Quiet {
+		throughput := newThroughputReporter(opts.ReportThroughput, &counters)
+		defer throughput.Stop()
+	}
+	var estimatedBytes atomic.Int64 // disk space of the rows a dry run would insert
+	skips := newSkipLogger(opts.CompactLogsEvery)
+	var recordErrs []error
+	var recordErrsMu sync.Mutex // batches can be written from the background
+	var interrupted atomic.Bool // a lookup was cancelled with ctx, its records are neither written nor failed
+	var cacheMu sync.Mutex      // guards latestByType and partitionCreated, workers share them
+	latestByType := make(map[string]time.Time)
+	partitionCreated := make(map[string]bool)
+
+	var deadLetter *csvRowWriter
+	if opts.DeadLetter != "" {
+		var err error
+		if deadLetter, err = newCSVRowWriter(opts.DeadLetter, cols.Header); err != nil {
+			return fmt.Errorf("dead letter file error: %w", err)
+		}
+
+		defer func() {
+			if err := deadLetter.Close(); err != nil {
+				log.Println("error closing dead letter file", err)
+			}
+		}()
+	}
+
+	var orphans *csvRowWriter
+	if opts.OrphansOut != "" && !opts.DryRun {
+		var err error
+		if orphans, err = newCSVRowWriter(opts.OrphansOut, []string{"id", "url", "type"}); err != nil {
+			return fmt.Errorf("orphans file error: %w", err)
+		}
+
+		defer func() {
+			if err := orphans.Close(); err != nil {
+				log.Println("error closing orphans file", err)
+			}
+		}()
+	}
+
+	var insertedIDs *insertedIDsWriter
+	if opts.IDsOut != "" && !opts.DryRun {
+		var err error
+		if insertedIDs, err = newInsertedIDsWriter(opts.IDsOut); err != nil {
+			return fmt.Errorf("ids file error: %w", err)
+		}
+
+		defer func() {
+			if err := insertedIDs.Close(); err != nil {
+				log.Println("error closing ids file", err)
+			}
+		}()
+	}
+
+	// recordFailures counts the records as failed and copies them to the dead letter file, then aborts the import
+	// in fail-fast mode, otherwise logs the error and keeps it for the final aggregate
+	recordFailures := func(records [][]string, err error) error {
+		if ctx.Err() != nil && (errors.Is(err, ctx.Err()) || pgconn.Timeout(err)) {
+			interrupted.Store(true)
+			return fmt.Errorf("%w: %v", errRecordInterrupted, err)
+		}
+		counters.failed.Add(int64(len(records)))
+		if dlErr := deadLetter.Write(records...); dlErr != nil {
+			return fmt.Errorf("dead letter write error: %w", dlErr)
+		}
+		if opts.FailFast {
+			return err
+		}
+		log.Println(err)
+		recordErrsMu.Lock()
+		recordErrs = append(recordErrs, err)
+		recordErrsMu.Unlock()
+		return nil
+	}
+	recordError := func(record []string, err error) error {
+		return recordFailures([][]string{record}, err)
+	}
+
+	// inflight keeps the records workers haven't finished, a resumed run starts after the handled ones
+	inflight := newInflightRecords()
+	var batch *batchWriter
+	// resumePosition returns how many records from the start of the input are written or settled: neither in flight,
+	// cancelled nor waiting in the batch
+	resumePosition := func() int64 {
+		handled := inflight.Handled(counters.read.Load())
+		if batch != nil {
+			if unwritten := batch.Unwritten(); unwritten > 0 && unwritten-1 < handled {
+				handled = unwritten - 1
+			}
+		}
+		return handled
+	}
+	if opts.Checkpoint != "" {
+		defer func() {
+			if err := writeCheckpoint(opts.Checkpoint, resumePosition()); err != nil {
+				log.Println("checkpoint write error", err)
+			}
+		}()
+	}
+
+	var sample *insertSample
+	if opts.PostSample > 0 && !opts.DryRun && !opts.Quiet {
+		sample = newInsertSample(opts.PostSample)
+	}
+
+	if opts.BatchSize > 1 {
+		// writeBatch inserts pending embeddings that all go to the same table
+		writeBatch := func(pending []pendingEmbedding) error {
+			route := pending[0].Route
+			embeddings := make([]models.Embeddings, len(pending))
+			records := make([][]string, len(pending))
+			for i, p := range pending {
+				embeddings[i] = p.Embedding
+				records[i] = p.Fields
+			}
+			first, last, n := pending[0].Line, pending[len(pending)-1].Line, int64(len(pending))
+			defer timings.insert.since(time.Now())
+
+			var inserted []uuid.UUID
+			err := writeWithRetry(writeDB.Table(route.Table), opts.WriteRetries, opts.QueryTimeout, "batch insert", first, func(db *gorm.DB) (err error) {
+				inserted, err = addEmbeddings(db, embeddings, insertConflict(route.Partitioned, route.NaturalKey))
+				return err
+			})
+			if err != nil {
+				return recordFailures(records, &RecordError{Line: first, LastLine: last, Kind: ErrWrite, Msg: "batch write error", Err: err})
+			}
+
+			if opts.SecondaryDB != nil {
+				err := writeWithRetry(opts.SecondaryDB.Table(route.Table), opts.WriteRetries, opts.QueryTimeout, "batch insert", first, func(db *gorm.DB) error {
+					_, err := addEmbeddings(db, embeddings, insertConflict(route.SecondaryPartitioned, route.SecondaryNaturalKey))
+					return err
+				})
+				if err != nil {
+					err = &RecordError{Line: first, LastLine: last, Kind: ErrWrite, Msg: "secondary batch write error", Err: err}
+					if !opts.SecondaryBestEffort {
+						return recordFailures(records, err)
+					}
+					log.Println(err)
+				}
+			}
+
+			// rows the conflict clause ignored were stored since the lookup, e.g. by an earlier record of the batch
+			isInserted := make(map[uuid.UUID]bool, len(inserted))
+			for _, id := range inserted {
+				isInserted[id] = true
+			}
+			written := make([]models.Embeddings, 0, len(inserted))
+			for i, e := range embeddings {
+				if isInserted[e.ID] {
+					written = append(written, e)
+					continue
+				}
+				counters.skipped.Add(1)
+				skips.Skip("embeddings already stored", fmt.Sprintf("line %d: embedding for entry %s type %s stored meanwhile", pending[i].Line, e.EntryID, e.Type))
+				if err := opts.DedupReport.Write(pending[i].Line, cols.url(pending[i].Fields), e.EntryID, e.Type, reasonAlreadyExists); err != nil {
+					return fmt.Errorf("dedup report write error: %w", err)
+				}
+			}
+
+			counters.inserted.Add(int64(len(written)))
+			if err := insertedIDs.Write(written...); err != nil {
+				return fmt.Errorf("ids file write error: %w", err)
+			}
+			sample.Add(route.Table, written...)
+			if !opts.Quiet {
+				fmt.Println("committed records ", n, " up to record ", pending[len(pending)-1].Read)
+			}
+			return nil
+		}
+
+		batch = newBatchWriter(opts.BatchSize, opts.CommitInterval, opts.MaxMemory, func(pending []pendingEmbedding) error {
+			// a batch can mix types routed to different tables, they are inserted table by table
+			var tables []string
+			byTable := make(map[string][]pendingEmbedding)
+			for _, p := range pending {
+				if _, ok := byTable[p.Route.Table]; !ok {
+					tables = append(tables, p.Route.Table)
+				}
+				byTable[p.Route.Table] = append(byTable[p.Route.Table], p)
+			}
+
+			for _, table := range tables {
+				if err := writeBatch(byTable[table]); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		})
+
+		defer func() {
+			if err := batch.Close(); err != nil {
+				log.Println("batch write error", err)
+			}
+		}()
+	}
+
+	var drift dimensionDrift
+
+	// gate orders the writes of concurrent workers, nil writes every record as soon as it is ready
+	var gate *orderGate
+	if opts.PreserveOrder && opts.Workers > 1 {
+		gate = newOrderGate()
+	}
+
+	process := func(rec Record, readCount int64) error {
+		var err error
+		var start time.Time
+		now := time.Now().UTC()
+		record, line := rec.Fields, rec.Line
+		trim(record)
+
+		if readCount <= int64(opts.StartFromLine) {
+			counters.skipped.Add(1)
+			skips.Skip("records before start line", "skip record ", readCount)
+			return nil
+		}
+
+		if trailer != nil && rec.Vector == nil {
+			if err := trailer.split(record, cols.Embedding); err != nil {
+				if err := recordError(record, newRecordError(line, ErrConvert, "embedding trailer error", err)); err != nil {
+					return err
+				}
+				return nil
+			}
+		}
+
+		if opts.AllowedURLs != nil {
+			if _, ok := opts.AllowedURLs[cols.url(record)]; !ok {
+				counters.skipped.Add(1)
+				counters.filtered.Add(1)
+				return nil
+			}
+		}
+
+		if filter != nil && !filter(record) {
+			counters.skipped.Add(1)
+			counters.rejected.Add(1)
+			return nil
+		}
+
+		if opts.RejectContentOver > 0 && cols.Content >= 0 && len(record[cols.Content]) > opts.RejectContentOver {
+			err := fmt.Errorf("content of %d bytes is over the %d byte limit", len(record[cols.Content]), opts.RejectContentOver)
+			if err := recordError(record, newRecordError(line, ErrConvert, "content rejected", err)); err != nil {
+				return err
+			}
+			return nil
+		}
+
+		if opts.EmbeddingJSON && rec.Vector == nil && cols.Embedding >= 0 {
+			if rec.Vector, err = decodeJSONEmbedding(record[cols.Embedding]); err != nil {
+				if err := recordError(record, newRecordError(line, ErrConvert, "record convert error", err)); err != nil {
+					return err
+				}
+				return nil
+			}
+		}
+
+		if opts.SkipEmptyEmbeddings && emptyEmbedding(rec, cols, opts.WideFormat) {
+			counters.skipped.Add(1)
+			skips.Skip("empty embeddings", fmt.Sprintf("line %d: empty embedding, skipped", line))
+			return nil
+		}
+
+		recordType := opts.Type
+		if recordType == "" {
+			recordType = record[cols.Type]
+		}
+		recordType += rec.TypeSuffix
+		storedType := recordType // -update-metadata-only matches stored rows before the type is mapped
+		if mapped, ok := opts.TypeMap[recordType]; ok {
+			recordType = mapped
+		}
+
+		route, ok := opts.Routes[recordType]
+		if !ok {
+			route = opts.Route
+		}
+		tableDB := db.Table(route.Table)
+		writeTableDB := writeDB.Table(route.Table)
+
+		dim, typeDim := opts.DimByType[recordType]
+		if !typeDim {
+			dim = opts.Dimension
+		}
+
+		if opts.HaltOnDrift {
+			if err := drift.check(recordDimension(rec, cols, opts.WideFormat, opts.VectorDelimiter), line, typeDim, dim); err != nil {
+				return err
+			}
+		}
+
+		if opts.OnlyNew {
+			cacheMu.Lock()
+			latest, ok := latestByType[recordType]
+			if !ok {
+				err = withTimeout(tableDB, opts.QueryTimeout, "latest created_at query", line, func(db *gorm.DB) (err error) {
+					latest, err = latestCreatedAt(db, recordType)
+					return err
+				})
+				if err == nil {
+					latestByType[recordType] = latest
+				}
+			}
+			cacheMu.Unlock()
+			if err != nil {
+				return fmt.Errorf("latest created_at query error: %w", err)
+			}
+
+			createdAt, err := recordCreatedAt(record, cols, now)
+			if err != nil {
+				if err := recordError(record, newRecordError(line, ErrConvert, "record convert error", err)); err != nil {
+					return err
+				}
+				return nil
+			}
+
+			if !createdAt.After(latest) {
+				counters.skipped.Add(1)
+				skips.Skip("records not newer than stored", "record not newer than stored embeddings, line ", line)
+				return nil
+			}
+		}
+
+		var entryID uuid.UUID
+		var entry *joinedEntry
+		var orphan bool // url not found and inserted anyway with -orphan-mode
+		if opts.CreateEntries {
+			if entry, err = recordEntry(record, cols); err != nil {
+				if err := recordError(record, newRecordError(line, ErrConvert, "content entry convert error", err)); err != nil {
+					return err
+				}
+				return nil
+			}
+			entryID = entry.ID
+		} else if cols.EntryID >= 0 {
+			if entryID, err = uuid.Parse(record[cols.EntryID]); err != nil {
+				if err := recordError(record, newRecordError(line, ErrConvert, fmt.Sprintf("invalid entry_id %q", record[cols.EntryID]), err)); err != nil {
+					return err
+				}
+				return nil
+			}
+		} else {
+			if opts.URLCheck != checkOff {
+				if err := validateURLFormat(cols.url(record)); err != nil {
+					err = newRecordError(line, ErrConvert, "invalid url", err)
+					if opts.URLCheck == checkWarn {
+						log.Println(err)
+					} else {
+						if err := recordError(record, err); err != nil {
+							return err
+						}
+						return nil
+					}
+				}
+			}
+
+			start = time.Now()
+			for attempt := 0; ; attempt++ {
+				err = withTimeout(db, opts.QueryTimeout, "find entry", line, func(db *gorm.DB) (err error) {
+					entryID, err = findEntryByURL(db, opts.URLColumn, cols.url(record))
+					return err
+				})
+				if errors.Is(err, gorm.ErrRecordNotFound) && opts.URLNormalizer != nil {
+					err = withTimeout(db, opts.QueryTimeout, "find entry by normalized url", line, func(db *gorm.DB) (err error) {
+						entryID, err = findEntryByNormalizedURL(db, opts.URLColumn, opts.URLNormalizer, cols.url(record))
+						return err
+					})
+					if err == nil {
+						counters.recovered.Add(1)
+					}
+				}
+				if !errors.Is(err, gorm.ErrRecordNotFound) || attempt >= opts.NotFoundRetries {
+					break
+				}
+
+				// the entry may be created moments after the embedding was exported
+				log.Printf("line %d: url not found, retry %d of %d in %s", line, attempt+1, opts.NotFoundRetries, opts.NotFoundDelay)
+				select {
+				case <-ctx.Done():
+				case <-time.After(opts.NotFoundDelay):
+				}
+				if ctx.Err() != nil {
+					break
+				}
+			}
+			timings.lookup.since(start)
+			if err != nil {
+				if errors.Is(err, gorm.ErrRecordNotFound) {
+					if err := opts.UnresolvedURLs.Write(cols.url(record)); err != nil {
+						return fmt.Errorf("unresolved urls write error: %w", err)
+					}
+					if !opts.OrphanMode || opts.UpdateMetadataOnly {
+						counters.skipped.Add(1)
+						skips.Skip("urls not found", "record url not found ", cols.url(record))
+						return nil
+					}
+					orphan, err = true, nil
+				} else {
+					if err := recordError(record, newRecordError(line, ErrLookup, "find entry error", err)); err != nil {
+						return err
+					}
+					return nil
+				}
+			}
+		}
+
+		if opts.UpdateMetadataOnly {
+			var content *string
+			if cols.Content >= 0 && !opts.NullContent[record[cols.Content]] {
+				content = &record[cols.Content]
+			}
+
+			var updated bool
+			start = time.Now()
+			if opts.DryRun {
+				err = withTimeout(tableDB, opts.QueryTimeout, "embedding exists", line, func(db *gorm.DB) (err error) {
+					updated, _, err = embeddingExists(db, entryID, storedType)
+					return err
+				})
+			} else {
+				err = writeWithRetry(writeTableDB, opts.WriteRetries, opts.QueryTimeout, "metadata update", line, func(db *gorm.DB) (err error) {
+					updated, err = updateEmbeddingMetadata(db, entryID, storedType, recordType, cols.Content >= 0, content)
+					return err
+				})
+				if err == nil && updated && opts.SecondaryDB != nil {
+					if err := writeWithRetry(opts.SecondaryDB.Table(route.Table), opts.WriteRetries, opts.QueryTimeout, "metadata update", line, func(db *gorm.DB) error {
+						_, err := updateEmbeddingMetadata(db, entryID, storedType, recordType, cols.Content >= 0, content)
+						return err
+					}); err != nil {
+						err = newRecordError(line, ErrWrite, "secondary metadata update error", err)
+						if !opts.SecondaryBestEffort {
+							if err := recordError(record, err); err != nil {
+								return err
+							}
+							return nil
+						}
+						log.Println(err)
+					}
+				}
+			}
+			timings.insert.since(start)
+			if err != nil {
+				if err := recordError(record, newRecordError(line, ErrWrite, "metadata update error", err)); err != nil {
+					return err
+				}
+				return nil
+			}
+
+			if !updated {
+				counters.skipped.Add(1)
+				skips.Skip("stored embeddings not found", fmt.Sprintf("line %d: no stored embedding for entry %s type %s", line, entryID, storedType))
+				return nil
+			}
+			counters.updated.Add(1)
+			return nil
+		}
+
+		// matched on the stored type, as -ids-out records it, so a reordered or edited input resumes correctly
+		if _, ok := opts.PreviouslyInserted[insertedKey{EntryID: entryID, Type: recordType}]; ok && !orphan {
+			counters.skipped.Add(1)
+			skips.Skip("records inserted by the resumed run", fmt.Sprintf("line %d: entry %s type %s inserted by the resumed run", line, entryID, recordType))
+			return nil
+		}
+
+		var exists bool
+		var existingID uuid.UUID
+		start = time.Now()
+		if !orphan {
+			err = withTimeout(tableDB, opts.QueryTimeout, "embedding lookup", line, func(db *gorm.DB) (err error) {
+				exists, existingID, err = embeddingExists(db, entryID, recordType)
+				return err
+			})
+		}
+		timings.exists.since(start)
+		if err != nil {
+			if err := recordError(record, newRecordError(line, ErrLookup, "embedding lookup error", err)); err != nil {
+				return err
+			}
+			return nil
+		}
+
+		if exists && route.ConflictPolicy == conflictError {
+			if err := recordError(record, newRecordError(line, ErrConflict, fmt.Sprintf("embedding already exists for entry %s, type %s, embedding id %s", entryID, recordType, existingID), nil)); err != nil {
+				return err
+			}
+			return nil
+		}
+
+		if exists && route.ConflictPolicy != conflictReplaceIfNewer {
+			counters.skipped.Add(1)
+			skips.Skip("embeddings already stored", "embedding exists for id ", entryID, " embedding id ", existingID)
+			if err := opts.DedupReport.Write(line, cols.url(record), entryID, recordType, reasonAlreadyExists); err != nil {
+				return fmt.Errorf("dedup report write error: %w", err)
+			}
+			return nil
+		}
+
+		start = time.Now()
+		if opts.LengthPrefixed && rec.Vector == nil && cols.Embedding >= 0 {
+			values, err := cutLengthPrefix(record[cols.Embedding], dim, opts.VectorDelimiter)
+			if err != nil {
+				if err := recordError(record, newRecordError(line, ErrConvert, "record convert error", err)); err != nil {
+					return err
+				}
+				return nil
+			}
+			record[cols.Embedding] = values
+		}
+
+		if rec.Vector == nil && cols.Embedding >= 0 && opts.VectorDelimiter == defaultVectorDelimiter {
+			if trimmed, ok := trimTrailingSeparator(record[cols.Embedding]); ok {
+				log.Printf("line %d: trailing separator in embedding ignored", line)
+				record[cols.Embedding] = trimmed
+			}
+		}
+
+		emb, err := convertRecord(rec, cols, dim, opts.VectorDelimiter, now, opts.NullContent)
+		timings.convert.since(start)
+		if err != nil {
+			if err := recordError(record, newRecordError(line, ErrConvert, "record convert error", err)); err != nil {
+				return err
+			}
+			return nil
+		}
+
+		if opts.WarnPrecisionLoss && rec.Vector == nil && cols.Embedding >= 0 {
+			if losses := precisionLoss(record[cols.Embedding], opts.VectorDelimiter, emb.Embedding, opts.FloatTolerance); len(losses) > 0 {
+				log.Printf("line %d: float32 precision loss in %d values, first at position %d: %s stored as %s",
+					line, len(losses), losses[0].Position, losses[0].OriginalValue, losses[0].ConvertedValue)
+			}
+		}
+
+		emb.Type = recordType
+		if orphan {
+			emb.Type += opts.OrphanTypeSuffix
+			url := cols.url(record)
+			emb.OrphanURL = &url
+		}
+		emb.EntryID = entryID
+		emb.ID = uuid.New()
+		if opts.EmbeddingHash {
+			hash := hashEmbedding(emb.Embedding)
+			emb.EmbeddingHash = &hash
+		}
+		if opts.BatchID != uuid.Nil {
+			emb.BatchID = &opts.BatchID
+		}
+
+		if opts.CheckFK && entry == nil && !orphan {
+			var ok bool
+			err = withTimeout(db, opts.QueryTimeout, "entry check", line, func(db *gorm.DB) (err error) {
+				ok, err = entryExists(db, entryID)
+				return err
+			})
+			if err != nil {
+				if err := recordError(record, newRecordError(line, ErrLookup, "entry check error", err)); err != nil {
+					return err
+				}
+				return nil
+			}
+			if !ok {
+				counters.skipped.Add(1)
+				skips.Skip("entries removed before insert", fmt.Sprintf("line %d: content entry %s was removed before insert", line, entryID))
+				return nil
+			}
+		}
+
+		// j, _ := json.MarshalIndent(emb, "", "\t")
+		// fmt.Println(string(j))
+
+		if opts.DryRun {
+			if exists {
+				counters.updated.Add(1)
+				return nil
+			}
+			var contentLen int
+			if emb.Content != nil {
+				contentLen = len(*emb.Content)
+			}
+			estimatedBytes.Add(estimateRowBytes(len(emb.Embedding), len(emb.Type), contentLen))
+			counters.inserted.Add(1)
+			if orphan {
+				counters.orphaned.Add(1)
+			}
+			return nil
+		}
+
+		cacheMu.Lock()
+		if opts.CreatePartitions && !partitionCreated[recordType] {
+			err = withTimeout(writeDB, opts.QueryTimeout, "create partition", line, func(db *gorm.DB) error {
+				return createPartition(db, route.Table, recordType)
+			})
+			if err == nil && route.SecondaryPartitioned {
+				err = withTimeout(opts.SecondaryDB, opts.QueryTimeout, "create partition", line, func(db *gorm.DB) error {
+					return createPartition(db, route.Table, recordType)
+				})
+			}
+			if err == nil {
+				partitionCreated[recordType] = true
+			}
+		}
+		cacheMu.Unlock()
+		if err != nil {
+			return fmt.Errorf("create partition for type %q error: %w", recordType, err)
+		}
+
+		// with -preserve-order everything before this point runs concurrently, the writes one by one in input order
+		if err := gate.Wait(readCount); err != nil {
+			return err
+		}
+
+		if batch != nil && !exists && entry == nil && !orphan {
+			if err := batch.Add(pendingEmbedding{Embedding: emb, Line: line, Read: readCount, Fields: record, Route: route}); err != nil {
+				return err
+			}
+			return nil
+		}
+
+		// write inserts the embedding, or replaces the stored one when it exists, and reports whether a row was
+		// written: conflict can ignore the insert and a replace only writes a newer embedding
+		write := func(db *gorm.DB, conflict clause.OnConflict) (written bool, err error) {
+			defer timings.insert.since(time.Now())
+			if entry != nil {
+				// the entry and its embedding are written together, a retry repeats both
+				err = writeWithRetry(db, opts.WriteRetries, opts.QueryTimeout, "insert with entry", line, func(db *gorm.DB) error {
+					return db.Transaction(func(tx *gorm.DB) (err error) {
+						if err := upsertContentEntry(tx, entry.ID, entry.EntryData, now); err != nil {
+							return err
+						}
+						if !exists {
+							written, err = addEmbedding(tx, emb, conflict)
+							return err
+						}
+						written, err = replaceEmbeddingIfNewer(tx, emb)
+						return err
+					})
+				})
+				return written, err
+			}
+			if orphan {
+				err = writeWithRetry(db, opts.WriteRetries, opts.QueryTimeout, "insert orphan", line, func(db *gorm.DB) (err error) {
+					written, err = addOrphanEmbedding(db, emb, conflict)
+					return err
+				})
+				return written, err
+			}
+			if !exists {
+				return addEmbeddingWithRetry(db, emb, conflict, opts.WriteRetries, opts.QueryTimeout, line)
+			}
+			err = writeWithRetry(db, opts.WriteRetries, opts.QueryTimeout, "replace", line, func(db *gorm.DB) (err error) {
+				written, err = replaceEmbeddingIfNewer(db, emb)
+				return err
+			})
+			return written, err
+		}
+
+		written, err := write(writeTableDB, insertConflict(route.Partitioned, route.NaturalKey))
+		if err != nil {
+			if err := recordError(record, newRecordError(line, ErrWrite, "record write error", err)); err != nil {
+				return err
+			}
+			return nil
+		}
+
+		if exists && !written {
+			counters.skipped.Add(1)
+			counters.unchanged.Add(1)
+			skips.Skip("stored embeddings not older", "stored embedding is not older, kept embedding id ", existingID)
+			return nil
+		}
+
+		if !written {
+			// stored since the lookup, by another run or worker
+			counters.skipped.Add(1)
+			skips.Skip("embeddings already stored", fmt.Sprintf("line %d: embedding for entry %s type %s stored meanwhile", line, emb.EntryID, emb.Type))
+			if err := opts.DedupReport.Write(line, cols.url(record), emb.EntryID, emb.Type, reasonAlreadyExists); err != nil {
+				return fmt.Errorf("dedup report write error: %w", err)
+			}
+			return nil
+		}
+
+		if opts.SecondaryDB != nil {
+			if _, err := write(opts.SecondaryDB.Table(route.Table), insertConflict(route.SecondaryPartitioned, route.SecondaryNaturalKey)); err != nil {
+				err = newRecordError(line, ErrWrite, "secondary write error", err)
+				if opts.SecondaryBestEffort {
+					log.Println(err)
+				} else {
+					if err := recordError(record, err); err != nil {
+						return err
+					}
+					return nil
+				}
+			}
+		}
+
+		if exists {
+			counters.updated.Add(1)
+			if !opts.Quiet {
+				fmt.Println("replaced record ", readCount)
+			}
+			return nil
+		}
+
+		counters.inserted.Add(1)
+		if err := insertedIDs.Write(emb); err != nil {
+			return fmt.Errorf("ids file write error: %w", err)
+		}
+		sample.Add(route.Table, emb)
+		if orphan {
+			counters.orphaned.Add(1)
+			if err := orphans.Write([]string{emb.ID.String(), cols.url(record), emb.Type}); err != nil {
+				return fmt.Errorf("orphans file write error: %w", err)
+			}
+		}
+
+		if !opts.Quiet {
+			fmt.Println("processed record ", readCount)
+		}
+		return nil
+	}
+
+	pool := newWorkerPool(opts.Workers, func(rec Record, readCount int64) error {
+		err := process(rec, readCount)
+		gate.Release(readCount)
+		if err != nil {
+			gate.Abort()
+			return err
+		}
+		inflight.Done(readCount)
+		return nil
+	})
+
+	for ctx.Err() == nil && pool.Err() == nil {
+		start := time.Now()
+		rec, err := records.Next()
+		timings.read.since(start)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			_ = pool.Wait()
+			return fmt.Errorf("unable to read record %w", err)
+		}
+
+		readCount := counters.read.Add(1)
+		skips.Tick(readCount - 1)
+		inflight.Start(readCount)
+		pool.Submit(rec, readCount)
+	}
+
+	if err := pool.Wait(); err != nil && !errors.Is(err, errRecordInterrupted) {
+		return err
+	}
+
+	if batch != nil {
+		if err := batch.Close(); err != nil && !errors.Is(err, errRecordInterrupted) {
+			return err
+		}
+	}
+
+	handled := counters.read.Load()
+	skips.Flush(handled)
+
+	if handled == 0 && ctx.Err() == nil {
+		log.Println("input has no data records, nothing to import")
+	}
+
+	fmt.Println("records read ", counters.read.Load())
+	fmt.Println("records added ", counters.inserted.Load())
+	fmt.Println("records skipped ", counters.skipped.Load())
+
+	if replaceIfNewer {
+		fmt.Println("records updated ", counters.updated.Load())
+		fmt.Println("records unchanged ", counters.unchanged.Load())
+	}
+
+	if opts.UpdateMetadataOnly {
+		fmt.Println("records with metadata updated ", counters.updated.Load())
+	}
+
+	if opts.OrphanMode {
+		fmt.Println("orphan embeddings created ", counters.orphaned.Load())
+	}
+
+	if opts.BatchID != uuid.Nil {
+		fmt.Println("batch id ", opts.BatchID)
+	}
+
+	if failed := counters.failed.Load(); failed > 0 {
+		fmt.Println("records failed ", failed)
+	}
+
+	if opts.URLNormalizer != nil {
+		fmt.Println("urls matched after normalization ", counters.recovered.Load())
+	}
+
+	if opts.AllowedURLs != nil {
+		fmt.Println("records filtered by urls file ", counters.filtered.Load())
+	}
+
+	if opts.Filter != "" {
+		fmt.Println("records rejected by filter ", counters.rejected.Load())
+	}
+
+	fmt.Println("timing ", timings.String())
+
+	if opts.DryRun {
+		fmt.Println("dry run, nothing was written")
+		fmt.Printf("estimated size of new rows: %s (%d bytes), without indexes\n", formatBytes(estimatedBytes.Load()), estimatedBytes.Load())
+	}
+
+	if interrupted.Load() {
+		log.Println("lookups in flight were cancelled, their records are left to the resumed run")
+	} else {
+		counters.reconcile()
+	}
+
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		log.Printf("max runtime reached, partial import will resume: %d records committed, %d records handled", counters.inserted.Load(), resumePosition())
+	case ctx.Err() != nil:
+		log.Printf("import interrupted, %d records committed, resume with -start-line %d", counters.inserted.Load(), resumePosition())
+		recordErrs = append(recordErrs, fmt.Errorf("import interrupted: %w", ctx.Err()))
+	}
+
+	if len(recordErrs) == 0 && ctx.Err() == nil {
+		// the sample is a sanity check, the import succeeded even if it can't be read
+		if err := sample.Print(ctx, db); err != nil {
+			log.Println("post-import sample error", err)
+		}
+	}
+
+	return errors.Join(recordErrs...)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestParseFloat32(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    float32
+		wantErr string
+	}{
+		{value: "0.25", want: 0.25},
+		{value: "-3.4028234e38", want: -math.MaxFloat32},
+		{value: "1e-50", want: 0},
+		// beyond float64, strconv reports ErrRange
+		{value: "1e400", wantErr: `value "1e400" overflows float32, position 3`},
+		{value: "-1e400", wantErr: `value "-1e400" overflows float32, position 3`},
+		// a valid float64 that doesn't fit float32
+		{value: "3.5e38", wantErr: `value "3.5e38" overflows float32, position 3`},
+		{value: "Inf", wantErr: `value "Inf" overflows float32, position 3`},
+		{value: "0.1x", wantErr: `error parsing value: strconv.ParseFloat: parsing "0.1x": invalid syntax, position 3`},
+	}
+
+	for _, tt := range tests {
+		got, err := parseFloat32(tt.value, 3)
+		if tt.wantErr != "" {
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("parseFloat32(%q) error = %v, want %q", tt.value, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseFloat32(%q) error: %v", tt.value, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseFloat32(%q) = %g, want %g", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestDecodeJSONEmbedding(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    []float32
+		wantErr string // prefix, the text of encoding/json errors varies between go versions
+	}{
+		{name: "array", value: "[1, -0.5, 3.4028234e38]", want: []float32{1, -0.5, math.MaxFloat32}},
+		{name: "quoted", value: `"[0.1, 0.2]"`, want: []float32{0.1, 0.2}},
+		{name: "empty", value: " ", want: []float32{}},
+		{name: "narrowing overflow", value: "[1, 1e39]", wantErr: "value 1e+39 overflows float32, position 1"},
+		{name: "float64 overflow", value: "[1e400]", wantErr: "invalid JSON embedding: json: cannot unmarshal number 1e400"},
+		{name: "invalid", value: "[1,", wantErr: "invalid JSON embedding: unexpected end of JSON input"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodeJSONEmbedding(tt.value)
+			if tt.wantErr != "" {
+				if err == nil || !strings.HasPrefix(err.Error(), tt.wantErr) {
+					t.Errorf("error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+			for i := range tt.want {
+				if got[i] != tt.want[i] {
+					t.Fatalf("got %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
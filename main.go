@@ -1,24 +1,14 @@
 package main
 
 import (
-	"encoding/csv"
-	"errors"
+	"context"
+	"flag"
 	"fmt"
-	"io"
 	"log"
 	"os"
-	"strconv"
-	"strings"
+	"os/signal"
+	"syscall"
 	"time"
-
-	"github.com/caarlos0/env/v9"
-	"github.com/google/uuid"
-	"github.com/joho/godotenv"
-	"gorm.io/driver/postgres"
-	"gorm.io/gorm"
-	"gorm.io/gorm/clause"
-
-	"github.com/denisb0/import_embeddings/models"
 )
 
 const embeddingSize = 1536
@@ -29,243 +19,84 @@ func panicOnError(err error) {
 	}
 }
 
-func getDBConn() (*gorm.DB, error) {
-	if err := godotenv.Load(); err != nil {
-		return nil, err
-	}
-
-	type Config struct {
-		DBHost     string `env:"DB_HOST,required"`
-		DBPort     string `env:"DB_PORT" envDefault:"5432"`
-		DBUser     string `env:"DB_USER,required"`
-		DBPassword string `env:"DB_PASSWORD,required"`
-		DBName     string `env:"DB_NAME,required"`
-	}
-
-	var cfg Config
-	if err := env.Parse(&cfg); err != nil {
-		return nil, err
-	}
-
-	dsn := fmt.Sprintf("host=%s user=%s password=%s dbname=%s port=%s application_name=%s",
-		cfg.DBHost, cfg.DBUser, cfg.DBPassword, cfg.DBName, cfg.DBPort, "yggdrasil")
-
-	return gorm.Open(postgres.Open(dsn), &gorm.Config{})
-}
-
-type verifyError struct {
-	Line           int
-	Position       int
-	OriginalValue  string
-	ConvertedValue string
-}
-
-func verify(f *os.File) ([]verifyError, error) {
-	csvReader := csv.NewReader(f)
-	_, err := csvReader.Read()
-	if err != nil {
-		return nil, fmt.Errorf("unable to parse file as CSV %w", err)
-	}
-
-	resp := make([]verifyError, 0)
-
-	var linesCount int
-	vectorBuffer := make([]float32, embeddingSize)
-
-	for {
-		record, err := csvReader.Read()
-		if err != nil {
-			if err == io.EOF {
-				break
-			}
-			return nil, fmt.Errorf("unable to parse file as CSV %w", err)
-		}
-
-		input := record[0]
-		input = strings.Trim(input, "[]")
-		strValues := strings.Split(input, ", ")
-		if len(strValues) != embeddingSize {
-			return nil, fmt.Errorf("vector size not equal embedding values size: %d, line: %d", len(strValues), linesCount)
-		}
-
-		var valuesChecked int
-		for i, strValue := range strValues {
-			value, err := strconv.ParseFloat(strValue, 32)
-			if err != nil {
-				return nil, fmt.Errorf("error parsing value: %v, line %d, position %d", err, linesCount, i)
-			}
-
-			vectorBuffer[i] = float32(value)
-
-			// compare
-			controlStr := strconv.FormatFloat(value, 'g', -1, 64)
-			if strValue != controlStr {
-				resp = append(resp, verifyError{
-					Line:           linesCount,
-					Position:       i,
-					OriginalValue:  strValue,
-					ConvertedValue: controlStr,
-				})
-			}
-			valuesChecked++
-		}
-
-		linesCount++
-
-		if linesCount > 10 {
-			break
-		}
-	}
-
-	fmt.Println("lines count: ", linesCount)
-
-	return resp, nil
+// commonOptions are flags shared by all commands, given before the command name
+type commonOptions struct {
+	EnvFile    string
+	PoolerMode string
+	DBLogLevel string
+	// connecting is tried again this many times with ConnectRetryDelay times the attempt number in between
+	ConnectRetries    int
+	ConnectRetryDelay time.Duration
+	FloatTolerance    floatTolerance // how verify and precision loss warnings compare written and stored values
 }
 
-func convertEmbedding(strEmbedding string, vectorBuffer []float32) error {
-	strEmbedding = strings.Trim(strEmbedding, "[]")
-	strValues := strings.Split(strEmbedding, ", ")
-
-	if len(strValues) != embeddingSize {
-		return fmt.Errorf("vector size not equal embedding values size: %d", len(strValues))
-	}
-
-	for i, strValue := range strValues {
-		value, err := strconv.ParseFloat(strValue, 32)
-		if err != nil {
-			return fmt.Errorf("error parsing value: %v, position %d", err, i)
-		}
-
-		vectorBuffer[i] = float32(value)
-	}
-
-	return nil
+type command struct {
+	Name        string
+	Description string
+	Run         func(ctx context.Context, common commonOptions, args []string) error
 }
 
-func convertRecord(record []string, now time.Time) (models.Embeddings, error) {
-	// header:  [embedding url content type]
-	buf := make([]float32, embeddingSize)
-	err := convertEmbedding(record[0], buf)
-	if err != nil {
-		return models.Embeddings{}, err
-	}
-
-	return models.Embeddings{
-		Embedding: buf,
-		Type:      record[3],
-		Content:   record[2],
-		CreatedAt: now,
-	}, nil
+var commands = []command{
+	{Name: "import", Description: "import embeddings from a file", Run: runImport},
+	{Name: "verify", Description: "check that embeddings in a file parse and round trip", Run: runVerify},
+	{Name: "export", Description: "export stored embeddings to CSV", Run: runExport},
+	{Name: "audit", Description: "report stored embeddings with the wrong dimension", Run: runAudit},
+	{Name: "reprocess-orphans", Description: "attach orphan embeddings whose url now has a content entry", Run: runReprocessOrphans},
+	{Name: "delete-batch", Description: "delete the embeddings written by an import run with -batch-id", Run: runDeleteBatch},
+	{Name: "stats", Description: "print stored embedding counts by type", Run: runStats},
+	{Name: "healthcheck", Description: "check database connectivity and tables", Run: runHealthcheck},
+	{Name: "dump-schema", Description: "print the DDL of the tables and indexes the tool expects, for psql", Run: runDumpSchema},
 }
 
-func findEntryByURL(db *gorm.DB, url string) (uuid.UUID, error) {
-	var entry models.ContentEntry
-	if err := db.Model(&models.ContentEntry{}).Where("entry_data->>'url' = ?", url).Take(&entry).Error; err != nil {
-		return uuid.UUID{}, err
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "usage: %s [common flags] <command> [command flags]\n\ncommands:\n", os.Args[0])
+	for _, c := range commands {
+		fmt.Fprintf(out, "  %-12s %s\n", c.Name, c.Description)
 	}
-
-	return entry.ID, nil
-}
-
-func addEmbedding(db *gorm.DB, embedding models.Embeddings) error {
-	return db.Clauses(clause.OnConflict{
-		Columns:   []clause.Column{{Name: "id"}},
-		DoNothing: true,
-	}).Create(embedding).Error
-}
-
-func embeddingExists(db *gorm.DB, entryID uuid.UUID) bool {
-	var data models.Embeddings
-	err := db.Take(&data, "entry_id = ?", entryID).Error
-	return err == nil
+	fmt.Fprintln(out, "\ncommon flags:")
+	flag.PrintDefaults()
 }
 
-func dump(f *os.File, db *gorm.DB, startFromLine int) error {
-	// try with local db first
-	csvReader := csv.NewReader(f)
-	_, err := csvReader.Read()
-	if err != nil {
-		return fmt.Errorf("unable to parse file as CSV %w", err)
+func main() {
+	var common commonOptions
+	flag.StringVar(&common.EnvFile, "env-file", ".env", "dotenv file with database settings")
+	flag.StringVar(&common.PoolerMode, "pooler", "", "connection pooler mode: session, transaction or simple, overrides DB_POOLER_MODE")
+	flag.StringVar(&common.DBLogLevel, "db-log-level", "warn", "gorm log level: silent, error, warn or info, info logs every SQL statement")
+	common.FloatTolerance = floatTolerance{Exact: true}
+	flag.Var(&common.FloatTolerance, "float-epsilon", "relative error up to which a value and the float32 stored for it are equal, in verify and -warn-precision-loss; exact requires the same bits, 5.96e-08 (2^-24) accepts any float32 rounding")
+	flag.IntVar(&common.ConnectRetries, "connect-retries", 0, "connect to a database that isn't accepting connections yet this many more times, e.g. while its container starts")
+	flag.DurationVar(&common.ConnectRetryDelay, "connect-retry-delay", 2*time.Second, "wait before the first -connect-retries attempt, every later one waits that much longer")
+	cpuProfile := flag.String("cpuprofile", "", "write a pprof CPU profile of the command to this file")
+	memProfile := flag.String("memprofile", "", "write a pprof heap profile to this file when the command ends")
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
 	}
 
-	var recordCount int
-
-	for {
-		now := time.Now().UTC()
-		record, err := csvReader.Read()
-		if err != nil {
-			if errors.Is(err, io.EOF) {
-				break
-			}
-			return fmt.Errorf("unable to parse file as CSV %w", err)
-		}
-
-		if recordCount < startFromLine {
-			recordCount++
-			log.Println("skip record ", recordCount+1)
-			continue
-		}
-
-		entryID, err := findEntryByURL(db, record[1])
-		if err != nil {
-			if errors.Is(err, gorm.ErrRecordNotFound) {
-				log.Println("record url not found ", record[1])
-				continue
-			}
-			return fmt.Errorf("find entry error: %w", err)
-		}
-
-		if embeddingExists(db, entryID) {
-			log.Println("embedding exists for id ", entryID)
-			continue
-		}
-
-		emb, err := convertRecord(record, now)
-		if err != nil {
-			return fmt.Errorf("record convert error: %w", err)
+	var cmd *command
+	for i := range commands {
+		if commands[i].Name == flag.Arg(0) {
+			cmd = &commands[i]
 		}
-
-		emb.EntryID = entryID
-		emb.ID = uuid.New()
-
-		// j, _ := json.MarshalIndent(emb, "", "\t")
-		// fmt.Println(string(j))
-
-		if err := addEmbedding(db, emb); err != nil {
-			return fmt.Errorf("record write error: %w", err)
-		}
-
-		recordCount++
-
-		fmt.Println("processed record ", recordCount)
-
-		// if recordCount >= 3 {
-		// 	break
-		// }
 	}
 
-	fmt.Println("records added ", recordCount)
+	if cmd == nil {
+		fmt.Fprintf(flag.CommandLine.Output(), "unknown command %q\n\n", flag.Arg(0))
+		flag.Usage()
+		os.Exit(2)
+	}
 
-	return nil
-}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-func main() {
-	db, err := getDBConn()
+	stopProfiles, err := startProfiles(*cpuProfile, *memProfile)
 	panicOnError(err)
 
-	f, err := os.Open("embedding.csv")
+	err = cmd.Run(ctx, common, flag.Args()[1:])
+	stopProfiles()
 	panicOnError(err)
-
-	defer func() {
-		if err := f.Close(); err != nil {
-			log.Println("error closing file", err)
-		}
-	}()
-
-	// resp, err := verify(f)
-	// panicOnError(err)
-
-	panicOnError(dump(f, db, 33530))
-
-	fmt.Println("processing complete")
 }
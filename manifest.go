@@ -0,0 +1,121 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// exportManifest describes an exported CSV so an import can check the file before using it
+type exportManifest struct {
+	Rows         int        `json:"rows"`
+	Dimension    int        `json:"dimension"` // 0 if vectors have different sizes
+	Types        []string   `json:"types"`
+	CreatedAtMin *time.Time `json:"created_at_min,omitempty"`
+	CreatedAtMax *time.Time `json:"created_at_max,omitempty"`
+	SHA256       string     `json:"sha256"` // hex checksum of the CSV
+}
+
+// add accounts for an exported row
+func (m *exportManifest) add(dimension int, embeddingType string, createdAt time.Time) {
+	if m.Rows == 0 {
+		m.Dimension = dimension
+	} else if m.Dimension != dimension {
+		m.Dimension = 0
+	}
+	m.Rows++
+
+	if i := sort.SearchStrings(m.Types, embeddingType); i == len(m.Types) || m.Types[i] != embeddingType {
+		m.Types = append(m.Types, "")
+		copy(m.Types[i+1:], m.Types[i:])
+		m.Types[i] = embeddingType
+	}
+
+	if m.CreatedAtMin == nil || createdAt.Before(*m.CreatedAtMin) {
+		m.CreatedAtMin = &createdAt
+	}
+	if m.CreatedAtMax == nil || createdAt.After(*m.CreatedAtMax) {
+		m.CreatedAtMax = &createdAt
+	}
+}
+
+func writeManifest(path string, m exportManifest) error {
+	data, err := json.MarshalIndent(m, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
+
+func readManifest(path string) (exportManifest, error) {
+	var m exportManifest
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return m, err
+	}
+
+	return m, json.Unmarshal(data, &m)
+}
+
+// checkManifest reads the whole CSV input once and compares its row count, dimension and checksum with the manifest
+func checkManifest(input string, gzipped bool, duplicates string, m exportManifest) error {
+	f, err := openInput(input, gzipped)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	checksum := sha256.New()
+	reader := csv.NewReader(io.TeeReader(f, checksum))
+	reader.ReuseRecord = true
+
+	header, err := readCSVHeader(reader, "input file")
+	if err != nil {
+		return err
+	}
+	cols, err := parseHeader(header, duplicates)
+	if err != nil {
+		return err
+	}
+
+	if m.Dimension > 0 && cols.Embedding < 0 && len(cols.Dims) != m.Dimension {
+		return fmt.Errorf("manifest mismatch: input has %d dim_N columns, manifest has dimension %d", len(cols.Dims), m.Dimension)
+	}
+
+	var rows int
+	for {
+		record, err := reader.Read()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return fmt.Errorf("unable to parse file as CSV %w", err)
+		}
+		rows++
+
+		if m.Dimension > 0 && cols.Embedding >= 0 {
+			embedding, _ := trimTrailingSeparator(record[cols.Embedding])
+			if n := strings.Count(embedding, ", ") + 1; n != m.Dimension {
+				return fmt.Errorf("manifest mismatch: record %d has dimension %d, manifest has %d", rows, n, m.Dimension)
+			}
+		}
+	}
+
+	if rows != m.Rows {
+		return fmt.Errorf("manifest mismatch: input has %d records, manifest has %d", rows, m.Rows)
+	}
+
+	if sum := hex.EncodeToString(checksum.Sum(nil)); sum != m.SHA256 {
+		return fmt.Errorf("manifest mismatch: input checksum %s, manifest has %s", sum, m.SHA256)
+	}
+
+	return nil
+}
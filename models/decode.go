@@ -0,0 +1,88 @@
+package models
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// ColumnTypeVector is a pgvector vector column, read with DecodeEmbedding
+const ColumnTypeVector = "vector"
+
+// DecodeEmbedding turns an embedding column value as scanned from the database, []byte or string, back into its
+// values. columnType is ColumnTypeReal, ColumnTypeBytea or ColumnTypeVector, the udt names _float4 and _float8 of
+// information_schema are accepted for real[]. A NULL value decodes to nil.
+func DecodeEmbedding(columnType string, src interface{}) ([]float32, error) {
+	if src == nil {
+		return nil, nil
+	}
+
+	switch columnType {
+	case ColumnTypeReal, "_float4", "_float8":
+		var values Float32Array
+		if err := values.Scan(src); err != nil {
+			return nil, err
+		}
+		return values, nil
+	case ColumnTypeBytea:
+		data, ok := src.([]byte)
+		if !ok {
+			return nil, fmt.Errorf("bytea embedding: unsupported value of type %T", src)
+		}
+		return FromBytes(data)
+	case ColumnTypeVector:
+		var text string
+		switch v := src.(type) {
+		case []byte:
+			text = string(v)
+		case string:
+			text = v
+		default:
+			return nil, fmt.Errorf("vector embedding: unsupported value of type %T", src)
+		}
+		return parseVectorText(text)
+	default:
+		return nil, fmt.Errorf("unknown embedding column type %q", columnType)
+	}
+}
+
+// FromBytes unpacks values written by Float32Array.Bytes
+func FromBytes(data []byte) (Float32Array, error) {
+	if len(data)%4 != 0 {
+		return nil, fmt.Errorf("bytea embedding of %d bytes is not a whole number of float32 values", len(data))
+	}
+
+	values := make(Float32Array, len(data)/4)
+	for i := range values {
+		values[i] = math.Float32frombits(binary.LittleEndian.Uint32(data[4*i:]))
+	}
+
+	return values, nil
+}
+
+// parseVectorText reads the pgvector text format, e.g. [1,2.5,-3]
+func parseVectorText(text string) (Float32Array, error) {
+	inner, ok := strings.CutPrefix(strings.TrimSpace(text), "[")
+	if ok {
+		inner, ok = strings.CutSuffix(inner, "]")
+	}
+	if !ok {
+		return nil, fmt.Errorf("vector embedding %q is not in [v1,v2,...] format", text)
+	}
+
+	values := make(Float32Array, 0, strings.Count(inner, ",")+1)
+	if strings.TrimSpace(inner) == "" {
+		return values, nil
+	}
+	for _, item := range strings.Split(inner, ",") {
+		v, err := strconv.ParseFloat(strings.TrimSpace(item), 32)
+		if err != nil {
+			return nil, fmt.Errorf("vector embedding: %w", err)
+		}
+		values = append(values, float32(v))
+	}
+
+	return values, nil
+}
@@ -0,0 +1,107 @@
+package models
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// vectorText formats values the way pgvector prints a vector column
+func vectorText(values []float32) string {
+	items := make([]string, len(values))
+	for i, v := range values {
+		items[i] = strconv.FormatFloat(float64(v), 'g', -1, 32)
+	}
+
+	return "[" + strings.Join(items, ",") + "]"
+}
+
+func TestDecodeEmbeddingRoundTrip(t *testing.T) {
+	vectors := [][]float32{
+		{},
+		{1},
+		{-0.5, 1e-7, 2.5, -3},
+		{math.MaxFloat32, -math.MaxFloat32, math.SmallestNonzeroFloat32, 0},
+		randomVector(1536),
+	}
+
+	encoders := []struct {
+		columnType string
+		encode     func(t *testing.T, values []float32) interface{}
+	}{
+		{columnType: ColumnTypeReal, encode: func(t *testing.T, values []float32) interface{} {
+			text, err := Float32Array(values).Value()
+			if err != nil {
+				t.Fatal(err)
+			}
+			return []byte(text.(string))
+		}},
+		{columnType: "_float4", encode: func(t *testing.T, values []float32) interface{} {
+			text, err := Float32Array(values).Value()
+			if err != nil {
+				t.Fatal(err)
+			}
+			return text
+		}},
+		{columnType: ColumnTypeBytea, encode: func(_ *testing.T, values []float32) interface{} {
+			return Float32Array(values).Bytes()
+		}},
+		{columnType: ColumnTypeVector, encode: func(_ *testing.T, values []float32) interface{} {
+			return vectorText(values)
+		}},
+		{columnType: ColumnTypeVector, encode: func(_ *testing.T, values []float32) interface{} {
+			return []byte(vectorText(values))
+		}},
+	}
+
+	for _, enc := range encoders {
+		t.Run(enc.columnType, func(t *testing.T) {
+			for _, vector := range vectors {
+				src := enc.encode(t, vector)
+				got, err := DecodeEmbedding(enc.columnType, src)
+				if err != nil {
+					t.Fatalf("DecodeEmbedding(%T of %d values) error: %v", src, len(vector), err)
+				}
+				if len(got) != len(vector) {
+					t.Fatalf("DecodeEmbedding(%T) returned %d values, want %d", src, len(got), len(vector))
+				}
+				for i := range vector {
+					if math.Float32bits(got[i]) != math.Float32bits(vector[i]) {
+						t.Fatalf("DecodeEmbedding(%T) value %d = %g, want %g", src, i, got[i], vector[i])
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestDecodeEmbeddingNull(t *testing.T) {
+	for _, columnType := range []string{ColumnTypeReal, ColumnTypeBytea, ColumnTypeVector} {
+		got, err := DecodeEmbedding(columnType, nil)
+		if err != nil || got != nil {
+			t.Errorf("DecodeEmbedding(%s, nil) = %v, %v, want nil, nil", columnType, got, err)
+		}
+	}
+}
+
+func TestDecodeEmbeddingErrors(t *testing.T) {
+	tests := []struct {
+		columnType string
+		src        interface{}
+		wantErr    string
+	}{
+		{columnType: ColumnTypeBytea, src: []byte{1, 2, 3}, wantErr: "bytea embedding of 3 bytes is not a whole number of float32 values"},
+		{columnType: ColumnTypeBytea, src: "abcd", wantErr: "bytea embedding: unsupported value of type string"},
+		{columnType: ColumnTypeVector, src: "1,2", wantErr: `vector embedding "1,2" is not in [v1,v2,...] format`},
+		{columnType: ColumnTypeVector, src: 1.5, wantErr: "vector embedding: unsupported value of type float64"},
+		{columnType: "text", src: "[1]", wantErr: `unknown embedding column type "text"`},
+	}
+
+	for _, tt := range tests {
+		_, err := DecodeEmbedding(tt.columnType, tt.src)
+		if err == nil || err.Error() != tt.wantErr {
+			t.Errorf("DecodeEmbedding(%s, %#v) error = %v, want %q", tt.columnType, tt.src, err, tt.wantErr)
+		}
+	}
+}
@@ -4,18 +4,31 @@ import (
 	"time"
 
 	"github.com/google/uuid"
-	"github.com/lib/pq"
 )
 
 type Embeddings struct {
-	ID        uuid.UUID       `gorm:"column:id;type:uuid" json:"id"`
-	EntryID   uuid.UUID       `gorm:"column:entry_id;type:uuid" json:"entry_id"`
-	Embedding pq.Float32Array `gorm:"column:embedding;type:real[]" json:"embedding"`
-	Type      string          `gorm:"column:type" json:"type"`       // provider, model and kind of content used to generate embedding like "azure_ada2_title_summary"
-	Content   string          `gorm:"column:content" json:"content"` // original content used to generate embedding
-	CreatedAt time.Time       `gorm:"column:created_at" json:"created_at"`
+	ID        uuid.UUID    `gorm:"column:id;type:uuid" json:"id"`
+	EntryID   uuid.UUID    `gorm:"column:entry_id;type:uuid" json:"entry_id"`
+	Embedding Float32Array `gorm:"column:embedding;type:real[]" json:"embedding"`
+	Type      string       `gorm:"column:type" json:"type"`       // provider, model and kind of content used to generate embedding like "azure_ada2_title_summary"
+	Content   *string      `gorm:"column:content" json:"content"` // original content used to generate embedding, nil is stored as NULL so the column must be nullable
+	CreatedAt time.Time    `gorm:"column:created_at" json:"created_at"`
+	// hex SHA-256 of the embedding as little-endian float32 bytes, only written when the import enables it
+	EmbeddingHash *string `gorm:"column:embedding_hash" json:"embedding_hash,omitempty"`
+	// run that wrote the embedding, only written when the import enables it
+	BatchID *uuid.UUID `gorm:"column:batch_id;type:uuid" json:"batch_id,omitempty"`
+	// url of an orphan embedding, stored without entry_id, to attach it once its content entry exists; only written
+	// by imports in orphan mode
+	OrphanURL *string `gorm:"column:orphan_url" json:"orphan_url,omitempty"`
 }
 
 func (e Embeddings) TableName() string {
 	return "embeddings"
 }
+
+// EmbeddingsBytea is the embeddings table with the vector in a bytea column, see Float32Array.Bytes for the layout.
+// It only creates the table, rows are written as Embeddings with ColumnTypeSetting.
+type EmbeddingsBytea struct {
+	Embeddings
+	Embedding []byte `gorm:"column:embedding;type:bytea" json:"embedding"`
+}
@@ -0,0 +1,74 @@
+package models
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/binary"
+	"math"
+	"strconv"
+
+	"github.com/lib/pq"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ColumnTypeSetting is the gorm setting, see gorm.DB.Set, that picks how a Float32Array is written: ColumnTypeBytea
+// packs it with Bytes, anything else writes a real[] literal
+const ColumnTypeSetting = "embeddings:column_type"
+
+// embedding column types
+const (
+	ColumnTypeReal  = "real[]"
+	ColumnTypeBytea = "bytea"
+)
+
+// Float32Array is a real[] column value. It formats like pq.Float32Array but sizes the buffer for the whole
+// array up front, so a 1536 value embedding is rendered with a single allocation besides the final string.
+type Float32Array []float32
+
+// float32TextSize is enough for most float32 values in shortest representation with the separator
+const float32TextSize = 16
+
+func (a Float32Array) Value() (driver.Value, error) {
+	if a == nil {
+		return nil, nil
+	}
+
+	buf := make([]byte, 0, 2+len(a)*float32TextSize)
+	buf = append(buf, '{')
+	for i, v := range a {
+		if i > 0 {
+			buf = append(buf, ',')
+		}
+		buf = strconv.AppendFloat(buf, float64(v), 'f', -1, 32)
+	}
+	buf = append(buf, '}')
+
+	return string(buf), nil
+}
+
+func (a *Float32Array) Scan(src interface{}) error {
+	return (*pq.Float32Array)(a).Scan(src)
+}
+
+// Bytes packs the values for a bytea column: every value as the 4 bytes of its IEEE 754 binary32 representation,
+// little-endian, one after another without header or separator, so the dimension is the length divided by 4.
+// Postgres can't compare or index such a column, it holds the vector for clients only.
+func (a Float32Array) Bytes() []byte {
+	buf := make([]byte, 4*len(a))
+	for i, v := range a {
+		binary.LittleEndian.PutUint32(buf[4*i:], math.Float32bits(v))
+	}
+
+	return buf
+}
+
+// GormValue writes the array as Bytes when the statement has ColumnTypeSetting set to ColumnTypeBytea
+func (a Float32Array) GormValue(ctx context.Context, db *gorm.DB) clause.Expr {
+	if columnType, ok := db.Get(ColumnTypeSetting); ok && columnType == ColumnTypeBytea && a != nil {
+		return clause.Expr{SQL: "?", Vars: []interface{}{a.Bytes()}}
+	}
+
+	value, _ := a.Value()
+	return clause.Expr{SQL: "?", Vars: []interface{}{value}}
+}
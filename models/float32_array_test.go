@@ -0,0 +1,60 @@
+package models
+
+import (
+	"database/sql/driver"
+	"math/rand"
+	"testing"
+
+	"github.com/lib/pq"
+)
+
+func randomVector(n int) []float32 {
+	rnd := rand.New(rand.NewSource(1))
+	vector := make([]float32, n)
+	for i := range vector {
+		vector[i] = float32(rnd.NormFloat64() / 40)
+	}
+
+	return vector
+}
+
+func TestFloat32ArrayValueMatchesPq(t *testing.T) {
+	for _, vector := range [][]float32{{}, {1}, {-0.5, 1e-7, 3.4e38}, randomVector(1536)} {
+		got, err := Float32Array(vector).Value()
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, err := pq.Float32Array(vector).Value()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("Value() = %v, want %v", got, want)
+		}
+	}
+}
+
+// BenchmarkFloat32ArrayValue compares formatting an embedding for a real[] column with pq.Float32Array, which
+// Float32Array replaced
+func BenchmarkFloat32ArrayValue(b *testing.B) {
+	vector := randomVector(1536)
+
+	benchmarks := []struct {
+		name  string
+		value driver.Valuer
+	}{
+		{name: "Float32Array", value: Float32Array(vector)},
+		{name: "pq.Float32Array", value: pq.Float32Array(vector)},
+	}
+
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := bm.value.Value(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
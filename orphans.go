@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+
+	"gorm.io/gorm"
+
+	"github.com/denisb0/import_embeddings/models"
+)
+
+func runReprocessOrphans(ctx context.Context, common commonOptions, args []string) error {
+	flags := flag.NewFlagSet("reprocess-orphans", flag.ExitOnError)
+	tables := flags.String("tables", models.Embeddings{}.TableName(), "comma separated tables holding orphan embeddings, add the -type-to-table ones")
+	typeSuffix := flags.String("orphan-type-suffix", "_orphan", "suffix the import appended to the type of orphan embeddings, removed when they are attached")
+	urlSource := flags.String("url-source", "json", "where content entries keep their url: json for entry_data->>'url', column for the url column")
+	limit := flags.Int("limit", 0, "look up at most this many orphans per table, 0 looks up all of them")
+	dryRun := flags.Bool("dry-run", false, "look up the urls and count the orphans that would be attached without changing them")
+	_ = flags.Parse(args)
+
+	urlColumn, ok := urlSources[*urlSource]
+	if !ok {
+		return fmt.Errorf("unsupported url source: %s", *urlSource)
+	}
+
+	db, err := getDBConn(common)
+	if err != nil {
+		return err
+	}
+	db = db.WithContext(ctx)
+
+	var scanned, resolved, conflicts int
+	for _, table := range splitList(*tables) {
+		orphans, err := findOrphanEmbeddings(db, table, *limit)
+		if err != nil {
+			return fmt.Errorf("%s: orphan query error: %w", table, err)
+		}
+
+		for _, o := range orphans {
+			scanned++
+			entryID, err := findEntryByURL(db, urlColumn, o.OrphanURL)
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("%s: find entry error for embedding %s: %w", table, o.ID, err)
+			}
+
+			if *dryRun {
+				resolved++
+				continue
+			}
+
+			attached, err := attachOrphanEmbedding(db, table, o.ID, entryID, strings.TrimSuffix(o.Type, *typeSuffix))
+			if err != nil {
+				return fmt.Errorf("%s: attach error for embedding %s: %w", table, o.ID, err)
+			}
+			if !attached {
+				conflicts++
+				continue
+			}
+			resolved++
+		}
+	}
+
+	fmt.Println("orphans scanned ", scanned)
+	if *dryRun {
+		fmt.Println("orphans with a content entry now ", resolved)
+		fmt.Println("dry run, nothing was changed")
+		return nil
+	}
+	fmt.Println("orphans resolved ", resolved)
+	fmt.Println("orphans left, embedding of the entry and type already stored ", conflicts)
+	fmt.Println("orphans left, url still not found ", scanned-resolved-conflicts)
+
+	return nil
+}
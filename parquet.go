@@ -0,0 +1,143 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// parquetColumns are the top level columns read from a Parquet file, in the order they are laid out in Record.Fields
+var parquetColumns = []string{"embedding", "url", "content", "type", "created_at", "entry_id"}
+
+// parquetRecordSource streams a Parquet file one row group at a time, so memory stays bounded by the row group size.
+// The embedding column can be a list of float or double values, other columns are read as strings.
+type parquetRecordSource struct {
+	rowGroups []parquet.RowGroup
+	rows      parquet.Rows
+	buf       []parquet.Row
+	fields    map[int]int // leaf column index -> position in Record.Fields
+	embedding int         // leaf column index of the embedding
+	numFields int
+	cols      recordColumns
+	rowNum    int
+}
+
+func newParquetRecordSource(f *os.File) (*parquetRecordSource, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	// the footer is read first, so pipes and other streams can't be used
+	if !info.Mode().IsRegular() {
+		return nil, errors.New("parquet input must be a regular file")
+	}
+
+	file, err := parquet.OpenFile(f, info.Size())
+	if err != nil {
+		return nil, fmt.Errorf("unable to open parquet file %w", err)
+	}
+
+	leafByName := make(map[string]int)
+	for i, path := range file.Schema().Columns() {
+		leafByName[path[0]] = i
+	}
+
+	source := &parquetRecordSource{
+		rowGroups: file.RowGroups(),
+		buf:       make([]parquet.Row, 1),
+		fields:    make(map[int]int),
+		embedding: -1,
+	}
+
+	header := make([]string, 0, len(parquetColumns))
+	for _, name := range parquetColumns {
+		leaf, ok := leafByName[name]
+		if !ok {
+			continue
+		}
+
+		if name == "embedding" {
+			source.embedding = leaf
+		}
+		source.fields[leaf] = len(header)
+		header = append(header, name)
+	}
+	source.numFields = len(header)
+
+	if source.cols, err = parseHeader(header, duplicateColumnsError); err != nil {
+		return nil, err
+	}
+
+	return source, nil
+}
+
+func (p *parquetRecordSource) Columns() recordColumns {
+	return p.cols
+}
+
+func (p *parquetRecordSource) Next() (Record, error) {
+	for {
+		if p.rows == nil {
+			if len(p.rowGroups) == 0 {
+				return Record{}, io.EOF
+			}
+			p.rows = p.rowGroups[0].Rows()
+			p.rowGroups = p.rowGroups[1:]
+		}
+
+		n, err := p.rows.ReadRows(p.buf)
+		if n == 1 {
+			p.rowNum++
+			return p.convertRow(p.buf[0]), nil
+		}
+		if err != nil && !errors.Is(err, io.EOF) {
+			return Record{}, err
+		}
+
+		if err := p.rows.Close(); err != nil {
+			return Record{}, err
+		}
+		p.rows = nil
+	}
+}
+
+func (p *parquetRecordSource) convertRow(row parquet.Row) Record {
+	rec := Record{
+		Line:   p.rowNum,
+		Fields: make([]string, p.numFields),
+		Vector: make([]float32, 0, embeddingSize),
+	}
+
+	for _, v := range row {
+		if v.IsNull() {
+			continue
+		}
+
+		if v.Column() == p.embedding {
+			switch v.Kind() {
+			case parquet.Float:
+				rec.Vector = append(rec.Vector, v.Float())
+			case parquet.Double:
+				rec.Vector = append(rec.Vector, float32(v.Double()))
+			}
+			continue
+		}
+
+		pos, ok := p.fields[v.Column()]
+		if !ok {
+			continue
+		}
+
+		if v.Kind() == parquet.ByteArray {
+			rec.Fields[pos] = string(v.ByteArray())
+		} else {
+			rec.Fields[pos] = v.String()
+		}
+	}
+
+	return rec
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/denisb0/import_embeddings/models"
+	"github.com/google/uuid"
+)
+
+const reasonAlreadyExists = "already_exists"
+
+// reportWriter writes per-record outcomes as CSV rows: line, url, entry_id, type, reason.
+// Methods are safe for concurrent use and on a nil writer, which discards everything.
+type reportWriter struct {
+	mu sync.Mutex
+	f  *os.File
+	w  *csv.Writer
+}
+
+func newReportWriter(path string) (*reportWriter, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	w := csv.NewWriter(f)
+	if err := w.Write([]string{"line", "url", "entry_id", "type", "reason"}); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+
+	return &reportWriter{f: f, w: w}, nil
+}
+
+func (r *reportWriter) Write(line int, url string, entryID uuid.UUID, embeddingType, reason string) error {
+	if r == nil {
+		return nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.w.Write([]string{strconv.Itoa(line), url, entryID.String(), embeddingType, reason})
+}
+
+func (r *reportWriter) Close() error {
+	if r == nil {
+		return nil
+	}
+
+	r.w.Flush()
+	if err := r.w.Error(); err != nil {
+		_ = r.f.Close()
+		return err
+	}
+
+	return r.f.Close()
+}
+
+// urlListWriter writes unique URLs one per line. Methods are safe for concurrent use and on a nil writer,
+// which discards everything.
+type urlListWriter struct {
+	mu   sync.Mutex
+	f    *os.File
+	w    *bufio.Writer
+	seen map[string]struct{}
+}
+
+func newURLListWriter(path string) (*urlListWriter, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &urlListWriter{f: f, w: bufio.NewWriter(f), seen: make(map[string]struct{})}, nil
+}
+
+func (u *urlListWriter) Write(url string) error {
+	if u == nil {
+		return nil
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if _, ok := u.seen[url]; ok {
+		return nil
+	}
+	u.seen[url] = struct{}{}
+
+	if _, err := u.w.WriteString(url); err != nil {
+		return err
+	}
+
+	return u.w.WriteByte('\n')
+}
+
+func (u *urlListWriter) Close() error {
+	if u == nil {
+		return nil
+	}
+
+	if err := u.w.Flush(); err != nil {
+		_ = u.f.Close()
+		return err
+	}
+
+	return u.f.Close()
+}
+
+// csvRowWriter writes CSV rows under a header, e.g. failed records under the input header for the dead letter file,
+// so it can be fixed and imported again. Methods are safe for concurrent use and on a nil writer, which discards
+// everything.
+type csvRowWriter struct {
+	mu sync.Mutex
+	f  *os.File
+	w  *csv.Writer
+}
+
+func newCSVRowWriter(path string, header []string) (*csvRowWriter, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	w := csv.NewWriter(f)
+	if err := w.Write(header); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+
+	return &csvRowWriter{f: f, w: w}, nil
+}
+
+func (d *csvRowWriter) Write(records ...[]string) error {
+	if d == nil {
+		return nil
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.w.WriteAll(records)
+}
+
+func (d *csvRowWriter) Close() error {
+	if d == nil {
+		return nil
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.w.Flush()
+	if err := d.w.Error(); err != nil {
+		_ = d.f.Close()
+		return err
+	}
+
+	return d.f.Close()
+}
+
+// insertedIDsWriter appends id, entry_id and type of inserted embeddings as CSV rows, the header is written only to
+// an empty file so several runs can share one. Methods are safe for concurrent use and on a nil writer, which
+// discards everything.
+type insertedIDsWriter struct {
+	mu sync.Mutex
+	f  *os.File
+	w  *csv.Writer
+}
+
+func newInsertedIDsWriter(path string) (*insertedIDsWriter, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+
+	w := csv.NewWriter(f)
+	if info.Size() == 0 {
+		if err := w.Write([]string{"id", "entry_id", "type"}); err != nil {
+			_ = f.Close()
+			return nil, err
+		}
+	}
+
+	return &insertedIDsWriter{f: f, w: w}, nil
+}
+
+func (i *insertedIDsWriter) Write(embeddings ...models.Embeddings) error {
+	if i == nil {
+		return nil
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	for _, e := range embeddings {
+		if err := i.w.Write([]string{e.ID.String(), e.EntryID.String(), e.Type}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (i *insertedIDsWriter) Close() error {
+	if i == nil {
+		return nil
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.w.Flush()
+	if err := i.w.Error(); err != nil {
+		_ = i.f.Close()
+		return err
+	}
+
+	return i.f.Close()
+}
+
+// insertedKey identifies an embedding recorded in an -ids-out file
+type insertedKey struct {
+	EntryID uuid.UUID
+	Type    string
+}
+
+// readInsertedIDs reads the (entry_id, type) pairs of an -ids-out file, columns are found by header name so extra
+// columns are ignored. Rows of orphan embeddings, whose entry_id is empty or the nil uuid, are left out.
+func readInsertedIDs(path string) (map[insertedKey]struct{}, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := csv.NewReader(bufio.NewReader(f))
+	header, err := r.Read()
+	if err != nil {
+		return nil, fmt.Errorf("%s: header read error: %w", path, err)
+	}
+
+	entryCol, typeCol := -1, -1
+	for i, name := range header {
+		switch name {
+		case "entry_id":
+			entryCol = i
+		case "type":
+			typeCol = i
+		}
+	}
+	if entryCol < 0 || typeCol < 0 {
+		return nil, fmt.Errorf("%s: entry_id and type columns are required, got %v", path, header)
+	}
+
+	inserted := make(map[insertedKey]struct{})
+	for {
+		row, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			return inserted, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+
+		if row[entryCol] == "" {
+			continue
+		}
+		entryID, err := uuid.Parse(row[entryCol])
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid entry_id %q: %w", path, row[entryCol], err)
+		}
+		if entryID == uuid.Nil {
+			continue
+		}
+		inserted[insertedKey{EntryID: entryID, Type: row[typeCol]}] = struct{}{}
+	}
+}
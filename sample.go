@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/denisb0/import_embeddings/models"
+)
+
+// shown of every sampled embedding
+const (
+	sampleContentRunes = 40
+	sampleValues       = 5
+)
+
+type sampledEmbedding struct {
+	Table string
+	ID    uuid.UUID
+}
+
+// insertSample keeps a uniform random sample of the embeddings an import inserted, by reservoir sampling, to read
+// them back when it ends. Methods are safe for concurrent use and on a nil sample, which keeps nothing.
+type insertSample struct {
+	mu      sync.Mutex
+	size    int
+	seen    int64
+	sampled []sampledEmbedding
+}
+
+func newInsertSample(size int) *insertSample {
+	return &insertSample{size: size}
+}
+
+// Add accounts for embeddings inserted into table
+func (s *insertSample) Add(table string, embeddings ...models.Embeddings) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, e := range embeddings {
+		s.seen++
+		if len(s.sampled) < s.size {
+			s.sampled = append(s.sampled, sampledEmbedding{Table: table, ID: e.ID})
+		} else if i := rand.Int63n(s.seen); i < int64(s.size) {
+			s.sampled[i] = sampledEmbedding{Table: table, ID: e.ID}
+		}
+	}
+}
+
+// Print reads the sampled embeddings back and prints their entry_id, type, the start of their content and their
+// first values
+func (s *insertSample) Print(ctx context.Context, db *gorm.DB) error {
+	if s == nil || len(s.sampled) == 0 {
+		return nil
+	}
+
+	var tables []string
+	byTable := make(map[string][]uuid.UUID)
+	for _, e := range s.sampled {
+		if _, ok := byTable[e.Table]; !ok {
+			tables = append(tables, e.Table)
+		}
+		byTable[e.Table] = append(byTable[e.Table], e.ID)
+	}
+
+	fmt.Printf("sample of %d inserted embeddings:\n", len(s.sampled))
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ENTRY ID\tTYPE\tCONTENT\tEMBEDDING")
+
+	var found int
+	for _, table := range tables {
+		columnType, err := embeddingColumnType(db.WithContext(ctx), table)
+		if err != nil {
+			return err
+		}
+
+		rows, err := db.WithContext(ctx).Table(table).
+			Select("entry_id, type, content, embedding").
+			Where("id IN ?", byTable[table]).
+			Rows()
+		if err != nil {
+			return fmt.Errorf("sample query error: %w", err)
+		}
+
+		for rows.Next() {
+			var entryID uuid.NullUUID
+			var embeddingType string
+			var content sql.NullString
+			var embedding interface{}
+			if err := rows.Scan(&entryID, &embeddingType, &content, &embedding); err != nil {
+				_ = rows.Close()
+				return fmt.Errorf("sample query error: %w", err)
+			}
+
+			vector, err := models.DecodeEmbedding(columnType, embedding)
+			if err != nil {
+				_ = rows.Close()
+				return err
+			}
+
+			entry := "NULL"
+			if entryID.Valid {
+				entry = entryID.UUID.String()
+			}
+			snippet := "NULL"
+			if content.Valid {
+				snippet = strconv.Quote(truncateRunes(content.String, sampleContentRunes))
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", entry, embeddingType, snippet, formatSampleValues(vector))
+			found++
+		}
+		if err := rows.Close(); err != nil {
+			return err
+		}
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("sample query error: %w", err)
+		}
+	}
+
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if missing := len(s.sampled) - found; missing > 0 {
+		fmt.Printf("%d sampled embeddings are no longer stored\n", missing)
+	}
+
+	return nil
+}
+
+// truncateRunes cuts s after n runes, marking the cut with ...
+func truncateRunes(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+
+	return string(runes[:n]) + "..."
+}
+
+// formatSampleValues renders the first values of a vector and its dimension
+func formatSampleValues(vector []float32) string {
+	n := len(vector)
+	if n > sampleValues {
+		n = sampleValues
+	}
+
+	values := make([]string, n)
+	for i := range values {
+		values[i] = strconv.FormatFloat(float64(vector[i]), 'g', 6, 32)
+	}
+	if len(vector) > n {
+		values = append(values, "...")
+	}
+
+	return fmt.Sprintf("[%s] (%d values)", strings.Join(values, ", "), len(vector))
+}
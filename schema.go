@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"time"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"github.com/denisb0/import_embeddings/models"
+)
+
+func runDumpSchema(_ context.Context, _ commonOptions, args []string) error {
+	flags := flag.NewFlagSet("dump-schema", flag.ExitOnError)
+	tables := flags.String("tables", models.Embeddings{}.TableName(), "comma separated embeddings tables to create, add the -type-to-table ones")
+	columnType := flags.String("column-type", models.ColumnTypeReal, "type of the embedding column: real[] or bytea, as given to import")
+	pgvector := flags.Bool("pgvector", false, "also create the vector extension")
+	urlSource := flags.String("url-source", "json", "where content entries keep their url: json for entry_data->>'url', column for the url column")
+	_ = flags.Parse(args)
+
+	switch *columnType {
+	case models.ColumnTypeReal:
+	case models.ColumnTypeBytea:
+		if *pgvector {
+			return errors.New("-pgvector can't be combined with -column-type bytea")
+		}
+	default:
+		return fmt.Errorf("unknown column type %q", *columnType)
+	}
+
+	urlColumn, ok := urlSources[*urlSource]
+	if !ok {
+		return fmt.Errorf("unsupported url source: %s", *urlSource)
+	}
+
+	statements, err := schemaDDL(splitList(*tables), *columnType, *pgvector, urlColumn)
+	if err != nil {
+		return err
+	}
+
+	for _, s := range statements {
+		fmt.Printf("%s;\n\n", s)
+	}
+
+	return nil
+}
+
+// schemaDDL returns the statements creating the tables and indexes the tool expects, the tables are generated from
+// the models by gorm in dry run mode so they can't drift from what import writes
+func schemaDDL(tables []string, columnType string, pgvector bool, urlColumn string) ([]string, error) {
+	recorder := &sqlRecorder{}
+	db, err := gorm.Open(postgres.New(postgres.Config{DSN: "host=localhost"}), &gorm.Config{
+		DryRun:                 true,
+		SkipDefaultTransaction: true,
+		DisableAutomaticPing:   true,
+		Logger:                 recorder,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("schema generation error: %w", err)
+	}
+
+	var statements []string
+	if pgvector {
+		statements = append(statements, "CREATE EXTENSION IF NOT EXISTS vector")
+	}
+
+	if err := db.Migrator().CreateTable(&models.ContentEntry{}); err != nil {
+		return nil, fmt.Errorf("%s schema generation error: %w", models.ContentEntry{}.TableName(), err)
+	}
+	statements = append(statements, recorder.Take()...)
+	// the model predates the url column of the newer schema
+	if urlColumn == urlSources["column"] {
+		statements = append(statements, fmt.Sprintf("ALTER TABLE %s ADD COLUMN IF NOT EXISTS url text", models.ContentEntry{}.TableName()))
+	}
+	statements = append(statements, urlIndexDDL(urlColumn, false))
+
+	var model interface{} = &models.Embeddings{}
+	if columnType == models.ColumnTypeBytea {
+		model = &models.EmbeddingsBytea{}
+	}
+	for _, table := range tables {
+		if err := db.Table(table).Migrator().CreateTable(model); err != nil {
+			return nil, fmt.Errorf("%s schema generation error: %w", table, err)
+		}
+		statements = append(statements, recorder.Take()...)
+		statements = append(statements, naturalKeyIndexDDL(table, false))
+	}
+
+	return statements, nil
+}
+
+// sqlRecorder is a gorm logger keeping the SQL of every statement instead of logging it
+type sqlRecorder struct {
+	statements []string
+}
+
+func (r *sqlRecorder) LogMode(logger.LogLevel) logger.Interface { return r }
+
+func (r *sqlRecorder) Info(context.Context, string, ...interface{}) {}
+
+func (r *sqlRecorder) Warn(context.Context, string, ...interface{}) {}
+
+func (r *sqlRecorder) Error(context.Context, string, ...interface{}) {}
+
+func (r *sqlRecorder) Trace(_ context.Context, _ time.Time, fc func() (string, int64), _ error) {
+	sql, _ := fc()
+	r.statements = append(r.statements, sql)
+}
+
+// Take returns the statements recorded since the last call
+func (r *sqlRecorder) Take() []string {
+	statements := r.statements
+	r.statements = nil
+
+	return statements
+}
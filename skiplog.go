@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+)
+
+// skipLogger logs why records are skipped. In compact mode messages are only counted by reason and a summary
+// of the counts is logged every `every` records read, so long runs of the same reason take one line.
+// It is safe for concurrent use.
+type skipLogger struct {
+	mu      sync.Mutex
+	every   int64 // 0 logs every message
+	counts  map[string]int64
+	reasons []string // in order of first occurrence since the last summary
+	last    int64    // records read at the last summary
+}
+
+func newSkipLogger(every int64) *skipLogger {
+	return &skipLogger{every: every, counts: make(map[string]int64)}
+}
+
+// Skip logs v as is, or in compact mode counts it under reason
+func (s *skipLogger) Skip(reason string, v ...any) {
+	if s.every <= 0 {
+		log.Println(v...)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.counts[reason]; !ok {
+		s.reasons = append(s.reasons, reason)
+	}
+	s.counts[reason]++
+}
+
+// Tick logs the summary when every records were read since the last one
+func (s *skipLogger) Tick(read int64) {
+	if s.every <= 0 {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if read-s.last >= s.every {
+		s.flush(read)
+	}
+}
+
+// Flush logs the counts collected since the last summary
+func (s *skipLogger) Flush(read int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.flush(read)
+}
+
+func (s *skipLogger) flush(read int64) {
+	if len(s.reasons) > 0 {
+		parts := make([]string, len(s.reasons))
+		for i, reason := range s.reasons {
+			parts[i] = fmt.Sprintf("%d %s", s.counts[reason], reason)
+		}
+		log.Printf("%s in the last %d rows", strings.Join(parts, ", "), read-s.last)
+	}
+
+	s.counts = make(map[string]int64)
+	s.reasons = s.reasons[:0]
+	s.last = read
+}
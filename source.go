@@ -0,0 +1,750 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// stdinPath is the -input value that reads from standard input
+const stdinPath = "-"
+
+// inputFile is an opened input, possibly decompressed, Close releases every underlying reader
+type inputFile struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (f *inputFile) Close() error {
+	var errs []error
+	for i := len(f.closers) - 1; i >= 0; i-- {
+		errs = append(errs, f.closers[i].Close())
+	}
+	return errors.Join(errs...)
+}
+
+// openInput opens path for reading, "-" is standard input. The input is decompressed when gzipped is set or path ends
+// with .gz, stdin has no name to sniff so it needs gzipped. The result is read sequentially and never seeked.
+func openInput(path string, gzipped bool) (*inputFile, error) {
+	f := os.Stdin
+	if path != stdinPath {
+		var err error
+		if f, err = os.Open(path); err != nil {
+			return nil, err
+		}
+		gzipped = gzipped || strings.HasSuffix(path, ".gz")
+	}
+
+	in := &inputFile{Reader: f, closers: []io.Closer{f}}
+	if gzipped {
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			_ = f.Close()
+			return nil, fmt.Errorf("unable to read gzip input %w", err)
+		}
+		in.Reader = zr
+		in.closers = append(in.closers, zr)
+	}
+
+	return in, nil
+}
+
+// Record is a data row together with its line in the input
+type Record struct {
+	Line   int
+	Fields []string
+	Vector []float32 // embedding already decoded by the source, Fields then holds no embedding text
+	// appended to the record type, set by embeddingColumnsSource for the embedding column the record came from
+	TypeSuffix string
+}
+
+// RecordSource yields input records until io.EOF, Columns tells where the known fields are in Record.Fields
+type RecordSource interface {
+	Columns() recordColumns
+	Next() (Record, error)
+}
+
+type csvRecordSource struct {
+	reader *csv.Reader
+	cols   recordColumns
+}
+
+func (c csvRecordSource) Columns() recordColumns {
+	return c.cols
+}
+
+func (c csvRecordSource) Next() (Record, error) {
+	fields, err := c.reader.Read()
+	if err != nil {
+		return Record{}, err
+	}
+
+	line, _ := c.reader.FieldPos(0)
+	return Record{Line: line, Fields: fields}, nil
+}
+
+// sidecarRecordSource zips an embeddings file with a metadata file holding url, content and the other columns,
+// records are paired by position and their fields joined, embeddings file first. Both files must have the same
+// number of records.
+type sidecarRecordSource struct {
+	embeddings *csv.Reader
+	metadata   *csv.Reader
+	cols       recordColumns
+	count      int
+}
+
+func newSidecarRecordSource(embeddings, metadata io.Reader, duplicates string, trimLeadingSpace bool) (*sidecarRecordSource, error) {
+	s := &sidecarRecordSource{embeddings: csv.NewReader(embeddings), metadata: csv.NewReader(metadata)}
+	s.embeddings.TrimLeadingSpace = trimLeadingSpace
+	s.metadata.TrimLeadingSpace = trimLeadingSpace
+
+	header, err := readCSVHeader(s.embeddings, "embeddings file")
+	if err != nil {
+		return nil, err
+	}
+	metaHeader, err := readCSVHeader(s.metadata, "metadata file")
+	if err != nil {
+		return nil, err
+	}
+
+	for _, name := range metaHeader {
+		// the embedding always comes from the embeddings file
+		if strings.TrimSpace(name) == "embedding" {
+			name = ""
+		}
+		header = append(header, name)
+	}
+
+	if s.cols, err = parseHeader(header, duplicates); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (s *sidecarRecordSource) Columns() recordColumns {
+	return s.cols
+}
+
+func (s *sidecarRecordSource) Next() (Record, error) {
+	fields, embErr := s.embeddings.Read()
+	metaFields, metaErr := s.metadata.Read()
+
+	switch {
+	case embErr == io.EOF && metaErr == io.EOF:
+		return Record{}, io.EOF
+	case embErr == io.EOF:
+		return Record{}, fmt.Errorf("row count mismatch: embeddings file ends after %d records, metadata file has more", s.count)
+	case metaErr == io.EOF:
+		return Record{}, fmt.Errorf("row count mismatch: metadata file ends after %d records, embeddings file has more", s.count)
+	case embErr != nil:
+		return Record{}, fmt.Errorf("embeddings file: %w", embErr)
+	case metaErr != nil:
+		return Record{}, fmt.Errorf("metadata file: %w", metaErr)
+	}
+	s.count++
+
+	line, _ := s.embeddings.FieldPos(0)
+	return Record{Line: line, Fields: append(fields, metaFields...)}, nil
+}
+
+// policies for an input file of a directory that can't be read
+const (
+	fileErrorStop = "stop"
+	fileErrorSkip = "skip-file"
+)
+
+// listInputFiles returns the *.csv and *.csv.gz files of dir in lexicographic order. With orderFile only the files
+// it lists, one name per line relative to dir, are returned in that order.
+func listInputFiles(dir, orderFile string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	found := make(map[string]bool)
+	for _, entry := range entries {
+		if entry.Type().IsRegular() && (strings.HasSuffix(entry.Name(), ".csv") || strings.HasSuffix(entry.Name(), ".csv.gz")) {
+			names = append(names, entry.Name())
+			found[entry.Name()] = true
+		}
+	}
+
+	if orderFile != "" {
+		data, err := os.ReadFile(orderFile)
+		if err != nil {
+			return nil, fmt.Errorf("file order read error: %w", err)
+		}
+
+		listed := make(map[string]bool)
+		names = names[:0]
+		for _, name := range strings.Split(string(data), "\n") {
+			if name = strings.TrimSpace(name); name == "" {
+				continue
+			}
+			if !found[name] {
+				return nil, fmt.Errorf("file order lists %q, which is not a csv file in %s", name, dir)
+			}
+			if listed[name] {
+				return nil, fmt.Errorf("file order lists %q twice", name)
+			}
+			listed[name] = true
+			names = append(names, name)
+		}
+
+		if ignored := len(found) - len(names); ignored > 0 {
+			log.Printf("%d csv files in %s are not in the file order and are ignored", ignored, dir)
+		}
+	}
+
+	if len(names) == 0 {
+		return nil, fmt.Errorf("no csv files to import in %s", dir)
+	}
+
+	paths := make([]string, len(names))
+	for i, name := range names {
+		paths[i] = filepath.Join(dir, name)
+	}
+
+	return paths, nil
+}
+
+// multiFileSource reads CSV files one after another as a single input, every file must have the header of the
+// first one. A file that can't be opened or parsed stops the import, or with fileErrorSkip the rest of it is
+// skipped and reading goes on with the next file. Record lines are positions in their own file.
+type multiFileSource struct {
+	paths            []string
+	onError          string
+	duplicates       string
+	trimLeadingSpace bool
+	embeddingColumns []embeddingColumn
+	cols             recordColumns
+	next             int // index in paths of the file to open after the current one
+	file             *inputFile
+	records          RecordSource
+	Imported         []string // files read to the end
+	Skipped          []string // files skipped after an error
+}
+
+func newMultiFileSource(paths []string, onError, duplicates string, trimLeadingSpace bool, embeddingColumns []embeddingColumn) (*multiFileSource, error) {
+	m := &multiFileSource{paths: paths, onError: onError, duplicates: duplicates, trimLeadingSpace: trimLeadingSpace, embeddingColumns: embeddingColumns}
+	if err := m.openNext(true); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+func (m *multiFileSource) Columns() recordColumns {
+	return m.cols
+}
+
+func (m *multiFileSource) Next() (Record, error) {
+	for m.records != nil {
+		record, err := m.records.Next()
+		if err == nil {
+			return record, nil
+		}
+
+		path := m.paths[m.next-1]
+		if errors.Is(err, io.EOF) {
+			m.Imported = append(m.Imported, path)
+		} else if err = m.fileError(path, err); err != nil {
+			return Record{}, err
+		}
+
+		if err := m.openNext(false); err != nil {
+			return Record{}, err
+		}
+	}
+
+	return Record{}, io.EOF
+}
+
+// openNext closes the current file and opens the next readable one, the first one sets the columns.
+// records is nil once no file is left.
+func (m *multiFileSource) openNext(first bool) error {
+	if err := m.Close(); err != nil {
+		log.Println("error closing file", err)
+	}
+	m.records = nil
+
+	for m.next < len(m.paths) {
+		path := m.paths[m.next]
+		m.next++
+
+		log.Printf("reading file %d of %d: %s", m.next, len(m.paths), path)
+		records, err := m.open(path, first)
+		if err == nil {
+			m.records = records
+			return nil
+		}
+		if err = m.fileError(path, err); err != nil {
+			return err
+		}
+	}
+
+	if first {
+		return errors.New("no input file could be read")
+	}
+
+	return nil
+}
+
+func (m *multiFileSource) open(path string, first bool) (RecordSource, error) {
+	f, err := openInput(path, false)
+	if err != nil {
+		return nil, err
+	}
+
+	records, err := openRecordSource(f.Reader, "csv", m.duplicates, m.trimLeadingSpace, m.embeddingColumns)
+	if err == nil && !first && !sameHeader(records.Columns().Header, m.cols.Header) {
+		err = fmt.Errorf("header %v differs from %v of the first file", records.Columns().Header, m.cols.Header)
+	}
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+
+	if first {
+		m.cols = records.Columns()
+	}
+	m.file = f
+
+	return records, nil
+}
+
+// fileError returns err for the file under fileErrorStop, otherwise it logs the error and notes the skipped file
+func (m *multiFileSource) fileError(path string, err error) error {
+	if m.onError != fileErrorSkip {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	log.Printf("skipping the rest of %s: %v", path, err)
+	m.Skipped = append(m.Skipped, path)
+	return nil
+}
+
+// Close closes the file being read, it can be called more than once
+func (m *multiFileSource) Close() error {
+	if m.file == nil {
+		return nil
+	}
+
+	err := m.file.Close()
+	m.file = nil
+	return err
+}
+
+func sameHeader(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// countRecords reads a CSV input to the end and returns the number of records after the header
+func countRecords(path string, gzipped bool) (int, error) {
+	f, err := openInput(path, gzipped)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	reader := csv.NewReader(f)
+	reader.ReuseRecord = true
+
+	if _, err := readCSVHeader(reader, path); err != nil {
+		return 0, err
+	}
+
+	var count int
+	for {
+		if _, err := reader.Read(); err != nil {
+			if err == io.EOF {
+				return count, nil
+			}
+			return 0, fmt.Errorf("unable to parse %s as CSV %w", path, err)
+		}
+		count++
+	}
+}
+
+// peekedSource returns already read records before the rest of its source
+type peekedSource struct {
+	RecordSource
+	peeked []Record
+}
+
+func (p *peekedSource) Next() (Record, error) {
+	if len(p.peeked) > 0 {
+		record := p.peeked[0]
+		p.peeked = p.peeked[1:]
+		return record, nil
+	}
+
+	return p.RecordSource.Next()
+}
+
+// shuffleSource reads records in chunks of size and returns every chunk in random order
+type shuffleSource struct {
+	source RecordSource
+	rnd    *rand.Rand
+	size   int
+	buf    []Record
+	err    error
+}
+
+func newShuffleSource(source RecordSource, size int, seed int64) *shuffleSource {
+	return &shuffleSource{
+		source: source,
+		rnd:    rand.New(rand.NewSource(seed)),
+		size:   size,
+		buf:    make([]Record, 0, size),
+	}
+}
+
+func (s *shuffleSource) Columns() recordColumns {
+	return s.source.Columns()
+}
+
+func (s *shuffleSource) Next() (Record, error) {
+	if len(s.buf) == 0 && s.err == nil {
+		s.fill()
+	}
+
+	if len(s.buf) == 0 {
+		return Record{}, s.err
+	}
+
+	record := s.buf[len(s.buf)-1]
+	s.buf = s.buf[:len(s.buf)-1]
+
+	return record, nil
+}
+
+func (s *shuffleSource) fill() {
+	for len(s.buf) < s.size {
+		record, err := s.source.Next()
+		if err != nil {
+			s.err = err
+			break
+		}
+		s.buf = append(s.buf, record)
+	}
+
+	s.rnd.Shuffle(len(s.buf), func(i, j int) {
+		s.buf[i], s.buf[j] = s.buf[j], s.buf[i]
+	})
+}
+
+// extraColumnsSource appends empty columns to the header and to every record of its source, for values the import
+// fills in itself
+type extraColumnsSource struct {
+	RecordSource
+	cols  recordColumns
+	extra int
+}
+
+// newExtraColumnsSource adds the named columns missing from the header of source, it returns source itself if none is
+func newExtraColumnsSource(source RecordSource, names []string, duplicates string) (RecordSource, error) {
+	header := source.Columns().Header
+	var extra []string
+	for _, name := range names {
+		found := false
+		for _, column := range append(header, extra...) {
+			if column == name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			extra = append(extra, name)
+		}
+	}
+	if len(extra) == 0 {
+		return source, nil
+	}
+
+	cols, err := parseHeader(append(header[:len(header):len(header)], extra...), duplicates)
+	if err != nil {
+		return nil, err
+	}
+
+	return &extraColumnsSource{RecordSource: source, cols: cols, extra: len(extra)}, nil
+}
+
+func (e *extraColumnsSource) Columns() recordColumns {
+	return e.cols
+}
+
+func (e *extraColumnsSource) Next() (Record, error) {
+	record, err := e.RecordSource.Next()
+	if err != nil {
+		return Record{}, err
+	}
+
+	record.Fields = append(record.Fields, make([]string, e.extra)...)
+	return record, nil
+}
+
+// readCSVHeader reads the first record, telling an empty input apart from a malformed one
+func readCSVHeader(reader *csv.Reader, name string) ([]string, error) {
+	header, err := reader.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("%s is empty, expected at least a header", name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("malformed %s, unable to parse header as CSV %w", name, err)
+	}
+
+	return header, nil
+}
+
+// openRecordSource reads records in format, trimLeadingSpace drops the spaces before every CSV field, content included.
+// With embeddingColumns every CSV row yields a record per listed column, see embeddingColumnsSource.
+func openRecordSource(r io.Reader, format, duplicates string, trimLeadingSpace bool, embeddingColumns []embeddingColumn) (RecordSource, error) {
+	if len(embeddingColumns) > 0 && format != "csv" {
+		return nil, fmt.Errorf("several embedding columns are only supported in csv input, not %s", format)
+	}
+
+	switch format {
+	case "csv":
+		csvReader := csv.NewReader(r)
+		csvReader.TrimLeadingSpace = trimLeadingSpace
+		header, err := readCSVHeader(csvReader, "input file")
+		if err != nil {
+			return nil, err
+		}
+
+		if len(embeddingColumns) > 0 {
+			return newEmbeddingColumnsSource(csvReader, header, duplicates, embeddingColumns)
+		}
+
+		cols, err := parseHeader(header, duplicates)
+		if err != nil {
+			return nil, err
+		}
+
+		return csvRecordSource{reader: csvReader, cols: cols}, nil
+	case "parquet":
+		f, ok := r.(*os.File)
+		if !ok {
+			return nil, errors.New("parquet input can't be compressed")
+		}
+		return newParquetRecordSource(f)
+	default:
+		return nil, fmt.Errorf("unknown input format: %s", format)
+	}
+}
+
+// embeddingColumn is an input column holding one of the embeddings of a row and the suffix of its type
+type embeddingColumn struct {
+	Column     string
+	TypeSuffix string
+}
+
+// embeddingColumnsSource reads rows with several embedding columns, like a title and a body embedding, and returns a
+// record per column, in the order of columns. The column's value is moved to an embedding column appended to the
+// header, so the rest of the import sees a single embedding, and its suffix is set as the record's TypeSuffix.
+// Records of one row share its line.
+type embeddingColumnsSource struct {
+	reader    *csv.Reader
+	cols      recordColumns
+	columns   []embeddingColumn
+	positions []int // of columns in the header
+	pending   []Record
+}
+
+func newEmbeddingColumnsSource(reader *csv.Reader, header []string, duplicates string, columns []embeddingColumn) (*embeddingColumnsSource, error) {
+	for _, name := range header {
+		if strings.TrimSpace(name) == "embedding" {
+			return nil, fmt.Errorf("header %v has an embedding column, it can't be combined with several embedding columns", header)
+		}
+	}
+
+	cols, err := parseHeader(append(header[:len(header):len(header)], "embedding"), duplicates)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &embeddingColumnsSource{reader: reader, cols: cols, columns: columns}
+	for _, c := range columns {
+		pos := -1
+		for i, name := range cols.Header {
+			if name == c.Column {
+				pos = i
+			}
+		}
+		if pos < 0 {
+			return nil, fmt.Errorf("embedding column %q not found in header %v", c.Column, header)
+		}
+		s.positions = append(s.positions, pos)
+	}
+
+	return s, nil
+}
+
+func (s *embeddingColumnsSource) Columns() recordColumns {
+	return s.cols
+}
+
+func (s *embeddingColumnsSource) Next() (Record, error) {
+	if len(s.pending) == 0 {
+		fields, err := s.reader.Read()
+		if err != nil {
+			return Record{}, err
+		}
+		line, _ := s.reader.FieldPos(0)
+
+		for i, c := range s.columns {
+			// only the embedding column keeps the vector text, so a record holds one copy of it
+			record := make([]string, len(fields)+1)
+			copy(record, fields)
+			for _, pos := range s.positions {
+				record[pos] = ""
+			}
+			record[len(fields)] = fields[s.positions[i]]
+			s.pending = append(s.pending, Record{Line: line, Fields: record, TypeSuffix: c.TypeSuffix})
+		}
+	}
+
+	record := s.pending[0]
+	s.pending = s.pending[1:]
+
+	return record, nil
+}
+
+// recordColumns holds positions of the known columns in the input header
+type recordColumns struct {
+	Header    []string // trimmed column names
+	Embedding int      // -1 if the file has no embedding column, it then has dim_N columns
+	Dims      []int    // positions of dim_0, dim_1, ... in the wide format, empty if there is no dim_0 column
+	URL       int      // -1 if the file has no url column, it then has an entry_id column
+	Content   int      // -1 if the file has no content column
+	Type      int      // -1 if the file has no type column
+	CreatedAt int      // -1 if the file has no created_at column
+	EntryID   int      // -1 if the file has no entry_id column, otherwise it replaces the url lookup
+	EntryData int      // -1 if the file has no entry_data column, the content entry JSON of a joined file
+}
+
+// policies for a column name that appears more than once in the header
+const (
+	duplicateColumnsError = "error"
+	duplicateColumnsFirst = "first"
+	duplicateColumnsLast  = "last"
+)
+
+// parseHeader finds the known columns, only an embedding and a way to find the content entry (url or entry_id)
+// are required here, other columns can be made required with requireColumns. A repeated column name is an error
+// unless duplicates says which of them to use.
+func parseHeader(header []string, duplicates string) (recordColumns, error) {
+	cols := recordColumns{Header: make([]string, len(header))}
+	positions := make(map[string]int, len(header))
+	for i, name := range header {
+		cols.Header[i] = strings.TrimSpace(name)
+		if _, ok := positions[cols.Header[i]]; ok && cols.Header[i] != "" {
+			switch duplicates {
+			case duplicateColumnsFirst:
+				continue
+			case duplicateColumnsLast:
+			default:
+				return recordColumns{}, fmt.Errorf("duplicate column %q in header %v, use -duplicate-columns first or last to pick one", cols.Header[i], header)
+			}
+		}
+		positions[cols.Header[i]] = i
+	}
+
+	position := func(name string) int {
+		if pos, ok := positions[name]; ok {
+			return pos
+		}
+		return -1
+	}
+
+	cols.Embedding = position("embedding")
+	cols.URL = position("url")
+	cols.Content = position("content")
+	cols.Type = position("type")
+	cols.CreatedAt = position("created_at")
+	cols.EntryID = position("entry_id")
+	cols.EntryData = position("entry_data")
+
+	for i := 0; ; i++ {
+		pos := position("dim_" + strconv.Itoa(i))
+		if pos < 0 {
+			break
+		}
+		cols.Dims = append(cols.Dims, pos)
+	}
+
+	if cols.Embedding < 0 && len(cols.Dims) == 0 {
+		return recordColumns{}, fmt.Errorf("required column %q not found in header %v", "embedding", header)
+	}
+	if cols.URL < 0 && cols.EntryID < 0 && cols.EntryData < 0 {
+		return recordColumns{}, fmt.Errorf("column %q, %q or %q required in header %v", "url", "entry_id", "entry_data", header)
+	}
+
+	return cols, nil
+}
+
+// requireColumns fails if any of the named columns is missing from the header, dim_N columns stand in for embedding
+func (c recordColumns) requireColumns(names []string) error {
+	for _, name := range names {
+		found := name == "embedding" && len(c.Dims) > 0
+		for _, column := range c.Header {
+			if column == name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("required column %q not found in header %v", name, c.Header)
+		}
+	}
+
+	return nil
+}
+
+// trimmer returns a function trimming surrounding whitespace from the values of the named columns in place,
+// names missing from the header are ignored
+func (c recordColumns) trimmer(names []string) func(record []string) {
+	var positions []int
+	for i, column := range c.Header {
+		for _, name := range names {
+			if column == name {
+				positions = append(positions, i)
+				break
+			}
+		}
+	}
+
+	return func(record []string) {
+		for _, i := range positions {
+			record[i] = strings.TrimSpace(record[i])
+		}
+	}
+}
+
+// url returns the record url, empty if the file has no url column
+func (c recordColumns) url(record []string) string {
+	if c.URL < 0 {
+		return ""
+	}
+	return record[c.URL]
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+const pipedCSV = "embedding,url,content,type\n" +
+	"\"[0.1, 0.2]\",https://example.com/a,first,title\n" +
+	"\"[0.3, 0.4]\",https://example.com/b,second,title\n"
+
+// pipeStdin replaces standard input with a pipe fed with data, like a shell pipe it has no size and can't be seeked
+func pipeStdin(t *testing.T, data []byte) {
+	t.Helper()
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stdin := os.Stdin
+	os.Stdin = r
+	t.Cleanup(func() {
+		os.Stdin = stdin
+		_ = r.Close()
+	})
+
+	go func() {
+		_, _ = w.Write(data)
+		_ = w.Close()
+	}()
+}
+
+func gzipData(t *testing.T, data string) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write([]byte(data)); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func TestOpenInputStdinPipe(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    []byte
+		gzipped bool
+	}{
+		{name: "plain", data: []byte(pipedCSV)},
+		{name: "gzip", data: gzipData(t, pipedCSV), gzipped: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pipeStdin(t, tt.data)
+
+			in, err := openInput(stdinPath, tt.gzipped)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer in.Close()
+
+			source, err := openRecordSource(in.Reader, "csv", duplicateColumnsError, false, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			cols := source.Columns()
+			var urls []string
+			for {
+				rec, err := source.Next()
+				if errors.Is(err, io.EOF) {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				urls = append(urls, rec.Fields[cols.URL])
+			}
+
+			if got, want := strings.Join(urls, " "), "https://example.com/a https://example.com/b"; got != want {
+				t.Errorf("urls = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestOpenRecordSourceParquetRejectsStreams(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    []byte
+		gzipped bool
+		wantErr string
+	}{
+		{name: "pipe", data: []byte("PAR1"), wantErr: "parquet input must be a regular file"},
+		{name: "gzip", data: gzipData(t, "PAR1"), gzipped: true, wantErr: "parquet input can't be compressed"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pipeStdin(t, tt.data)
+
+			in, err := openInput(stdinPath, tt.gzipped)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer in.Close()
+
+			_, err = openRecordSource(in.Reader, "parquet", duplicateColumnsError, false, nil)
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
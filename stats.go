@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/denisb0/import_embeddings/models"
+)
+
+type typeStats struct {
+	Type           string
+	Count          int64
+	FirstCreatedAt time.Time
+	LastCreatedAt  time.Time
+}
+
+func runStats(ctx context.Context, common commonOptions, args []string) error {
+	flags := flag.NewFlagSet("stats", flag.ExitOnError)
+	_ = flags.Parse(args)
+
+	db, err := getDBConn(common)
+	if err != nil {
+		return err
+	}
+
+	var stats []typeStats
+	if err := db.WithContext(ctx).Model(&models.Embeddings{}).
+		Select("type, count(*) AS count, min(created_at) AS first_created_at, max(created_at) AS last_created_at").
+		Group("type").
+		Order("type").
+		Scan(&stats).Error; err != nil {
+		return fmt.Errorf("stats query error: %w", err)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TYPE\tCOUNT\tFIRST CREATED\tLAST CREATED")
+	for _, s := range stats {
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\n", s.Type, s.Count, s.FirstCreatedAt.Format(time.RFC3339), s.LastCreatedAt.Format(time.RFC3339))
+	}
+
+	return w.Flush()
+}
+
+func runHealthcheck(ctx context.Context, common commonOptions, args []string) error {
+	flags := flag.NewFlagSet("healthcheck", flag.ExitOnError)
+	_ = flags.Parse(args)
+
+	db, err := getDBConn(common)
+	if err != nil {
+		return err
+	}
+
+	sqlDB, err := db.DB()
+	if err != nil {
+		return err
+	}
+
+	if err := sqlDB.PingContext(ctx); err != nil {
+		return fmt.Errorf("database ping error: %w", err)
+	}
+
+	for _, table := range []string{models.Embeddings{}.TableName(), models.ContentEntry{}.TableName()} {
+		if !db.WithContext(ctx).Migrator().HasTable(table) {
+			return fmt.Errorf("table %s not found", table)
+		}
+	}
+
+	fmt.Println("ok")
+
+	return nil
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+)
+
+// import states written to the status file
+const (
+	statusRunning   = "running"
+	statusCompleted = "completed"
+	statusStopped   = "stopped" // max runtime reached or interrupted, the import can be resumed
+	statusFailed    = "failed"
+)
+
+// importStatus is the content of the status file, read by external monitoring
+type importStatus struct {
+	State            string    `json:"state"`
+	StartedAt        time.Time `json:"started_at"`
+	UpdatedAt        time.Time `json:"updated_at"`
+	Read             int64     `json:"read"`
+	Inserted         int64     `json:"inserted"`
+	Updated          int64     `json:"updated"`
+	Skipped          int64     `json:"skipped"`
+	Failed           int64     `json:"failed"`
+	Filtered         int64     `json:"filtered"`
+	RecordsPerSecond float64   `json:"records_per_second"`
+}
+
+// statusWriter rewrites the status file with the current counters every interval and once more when stopped
+type statusWriter struct {
+	path      string
+	counters  *importCounters
+	startedAt time.Time
+	stop      chan struct{}
+	done      chan struct{}
+}
+
+func newStatusWriter(path string, interval time.Duration, counters *importCounters) *statusWriter {
+	s := &statusWriter{
+		path:      path,
+		counters:  counters,
+		startedAt: time.Now().UTC(),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	s.write(statusRunning)
+
+	go s.writeEvery(interval)
+
+	return s
+}
+
+func (s *statusWriter) writeEvery(interval time.Duration) {
+	defer close(s.done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			s.write(statusRunning)
+		}
+	}
+}
+
+// Stop ends the periodic writes and writes the final state
+func (s *statusWriter) Stop(state string) {
+	close(s.stop)
+	<-s.done
+
+	s.write(state)
+}
+
+// write errors are only logged, monitoring must not break the import
+func (s *statusWriter) write(state string) {
+	now := time.Now().UTC()
+	status := importStatus{
+		State:     state,
+		StartedAt: s.startedAt,
+		UpdatedAt: now,
+		Read:      s.counters.read.Load(),
+		Inserted:  s.counters.inserted.Load(),
+		Updated:   s.counters.updated.Load(),
+		Skipped:   s.counters.skipped.Load(),
+		Failed:    s.counters.failed.Load(),
+		Filtered:  s.counters.filtered.Load(),
+	}
+	if elapsed := now.Sub(s.startedAt).Seconds(); elapsed > 0 {
+		status.RecordsPerSecond = float64(status.Read) / elapsed
+	}
+
+	data, err := json.MarshalIndent(status, "", "\t")
+	if err == nil {
+		err = writeFileAtomic(s.path, append(data, '\n'))
+	}
+	if err != nil {
+		log.Println("status file write error", err)
+	}
+}
+
+// throughputReporter logs the records per second over the last interval and since the start, every interval
+type throughputReporter struct {
+	counters *importCounters
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+func newThroughputReporter(interval time.Duration, counters *importCounters) *throughputReporter {
+	t := &throughputReporter{counters: counters, stop: make(chan struct{}), done: make(chan struct{})}
+
+	go t.reportEvery(interval)
+
+	return t
+}
+
+func (t *throughputReporter) reportEvery(interval time.Duration) {
+	defer close(t.done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	start := time.Now()
+	last, lastRead := start, t.counters.read.Load()
+	for {
+		select {
+		case <-t.stop:
+			return
+		case now := <-ticker.C:
+			read := t.counters.read.Load()
+			log.Printf("throughput: %.1f records/s over the last %s, %.1f records/s overall, %d read, %d inserted",
+				float64(read-lastRead)/now.Sub(last).Seconds(), now.Sub(last).Round(time.Second),
+				float64(read)/now.Sub(start).Seconds(), read, t.counters.inserted.Load())
+			last, lastRead = now, read
+		}
+	}
+}
+
+// Stop ends the reports
+func (t *throughputReporter) Stop() {
+	close(t.stop)
+	<-t.done
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// readURLList reads a newline delimited list of URLs, blank lines are ignored
+func readURLList(path string) (map[string]struct{}, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	urls := make(map[string]struct{})
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if u := strings.TrimSpace(scanner.Text()); u != "" {
+			urls[u] = struct{}{}
+		}
+	}
+
+	return urls, scanner.Err()
+}
+
+// urlNormalizer canonicalizes URLs: lowercase scheme and host, no trailing slash in the path,
+// query parameters sorted and the ones listed in dropParams removed. A dropParams item ending
+// with * matches every parameter with that prefix, e.g. utm_*.
+type urlNormalizer struct {
+	dropParams []string
+}
+
+func newURLNormalizer(dropParams string) *urlNormalizer {
+	n := &urlNormalizer{}
+	for _, p := range strings.Split(dropParams, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			n.dropParams = append(n.dropParams, p)
+		}
+	}
+
+	return n
+}
+
+// Normalize returns the canonical form of rawURL, or rawURL itself if it can't be parsed
+func (n *urlNormalizer) Normalize(rawURL string) string {
+	u, err := url.Parse(strings.TrimSpace(rawURL))
+	if err != nil {
+		return rawURL
+	}
+
+	u.Scheme = strings.ToLower(u.Scheme)
+	u.Host = strings.ToLower(u.Host)
+	u.Path = strings.TrimRight(u.Path, "/")
+	u.RawPath = strings.TrimRight(u.RawPath, "/")
+
+	if u.RawQuery != "" {
+		query := u.Query()
+		for param := range query {
+			if n.drop(param) {
+				query.Del(param)
+			}
+		}
+		u.RawQuery = query.Encode()
+	}
+
+	return u.String()
+}
+
+func (n *urlNormalizer) drop(param string) bool {
+	for _, p := range n.dropParams {
+		if prefix, ok := strings.CutSuffix(p, "*"); ok {
+			if strings.HasPrefix(param, prefix) {
+				return true
+			}
+		} else if param == p {
+			return true
+		}
+	}
+
+	return false
+}
+
+// modes of the checks done before importing, see -validate-url-format and -session-check
+const (
+	checkOff  = "off"
+	checkWarn = "warn"
+	checkFail = "fail"
+)
+
+// validateURLFormat fails for values that are not absolute URLs with a host, those can't match a content entry
+func validateURLFormat(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return fmt.Errorf("%q is not an absolute url", rawURL)
+	}
+
+	return nil
+}
@@ -0,0 +1,377 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+)
+
+func runVerify(_ context.Context, common commonOptions, args []string) error {
+	flags := flag.NewFlagSet("verify", flag.ExitOnError)
+	input := flags.String("input", "embedding.csv", "path of the input file, - reads standard input")
+	gzipped := flags.Bool("gzip", false, "input is gzip compressed, implied by a .gz extension")
+	limit := flags.Int("limit", 0, "number of records to check, 0 checks the whole file")
+	maxReported := flags.Int("max-reported", 20, "number of mismatches to print")
+	checkNorms := flags.Bool("norms", false, "report the distribution of vector L2 norms")
+	normMin := flags.Float64("norm-min", 0.9, "lower bound of the expected L2 norm band")
+	normMax := flags.Float64("norm-max", 1.1, "upper bound of the expected L2 norm band")
+	embeddingOnly := flags.Bool("embedding-only", false, "only check that every embedding parses into -dim finite float32 values, fails if any doesn't")
+	dim := flags.Int("dim", embeddingSize, "number of values in every embedding")
+	vectorDelimiter := flags.String("vector-delimiter", defaultVectorDelimiter, "separator of the values in the embedding column, e.g. ; or a space, which accepts any whitespace")
+	_ = flags.Parse(args)
+
+	if *dim <= 0 {
+		return errors.New("-dim must be positive")
+	}
+	layout := embeddingLayout{Dim: *dim, Delimiter: *vectorDelimiter}
+
+	f, err := openInput(*input, *gzipped)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if err := f.Close(); err != nil {
+			log.Println("error closing file", err)
+		}
+	}()
+
+	if *embeddingOnly {
+		failures, err := validateEmbeddings(f, layout, *limit, *maxReported)
+		if err != nil {
+			return err
+		}
+		for _, fe := range failures {
+			fmt.Printf("line %d: %v\n", fe.Line, fe.Err)
+		}
+		if len(failures) > 0 {
+			return fmt.Errorf("invalid embeddings found, first at line %d", failures[0].Line)
+		}
+		fmt.Println("all embeddings valid")
+		return nil
+	}
+
+	var norms *normStats
+	if *checkNorms {
+		norms = &normStats{BandMin: *normMin, BandMax: *normMax}
+	}
+
+	resp, err := verify(f, layout, *limit, norms, common.FloatTolerance)
+	if err != nil {
+		return err
+	}
+
+	for i, ve := range resp {
+		if i >= *maxReported {
+			break
+		}
+		fmt.Printf("line %d, position %d: %s converted to %s\n", ve.Line, ve.Position, ve.OriginalValue, ve.ConvertedValue)
+	}
+
+	fmt.Println("mismatches: ", len(resp))
+
+	if norms != nil {
+		fmt.Printf("norms: min %g, max %g, mean %g, zero vectors %d, outside [%g, %g] %d\n",
+			norms.Min, norms.Max, norms.Mean(), norms.Zero, norms.BandMin, norms.BandMax, norms.OutOfBand)
+	}
+
+	return nil
+}
+
+// normStats summarizes L2 norms of vectors, vectors with a norm outside [BandMin, BandMax] are counted as out of band
+type normStats struct {
+	BandMin   float64
+	BandMax   float64
+	Count     int
+	Zero      int
+	OutOfBand int
+	Min       float64
+	Max       float64
+	Sum       float64
+}
+
+func (n *normStats) Add(vector []float32) {
+	var sum float64
+	for _, v := range vector {
+		sum += float64(v) * float64(v)
+	}
+	norm := math.Sqrt(sum)
+
+	if n.Count == 0 || norm < n.Min {
+		n.Min = norm
+	}
+	if n.Count == 0 || norm > n.Max {
+		n.Max = norm
+	}
+	n.Count++
+	n.Sum += norm
+
+	if norm == 0 {
+		n.Zero++
+	}
+	if norm < n.BandMin || norm > n.BandMax {
+		n.OutOfBand++
+	}
+}
+
+func (n *normStats) Mean() float64 {
+	if n.Count == 0 {
+		return 0
+	}
+
+	return n.Sum / float64(n.Count)
+}
+
+// embeddingLayout is how the embeddings of the input are written, given by the import flags of the same names
+type embeddingLayout struct {
+	Dim       int    // -dim
+	Delimiter string // -vector-delimiter
+}
+
+// embeddingColumnIndex finds the embedding column in a CSV header like parseHeader does
+func embeddingColumnIndex(header []string) (int, error) {
+	for i, name := range header {
+		if strings.TrimSpace(name) == "embedding" {
+			return i, nil
+		}
+	}
+
+	return -1, fmt.Errorf("required column %q not found in header %v", "embedding", header)
+}
+
+// embeddingFailure is an embedding that doesn't parse
+type embeddingFailure struct {
+	Line int
+	Err  error
+}
+
+// validateEmbeddings only parses the embedding column of up to limit records (0 for all), nothing else in the record
+// is looked at. It stops after maxFailures failures (at least one).
+func validateEmbeddings(r io.Reader, layout embeddingLayout, limit, maxFailures int) ([]embeddingFailure, error) {
+	csvReader := csv.NewReader(r)
+	csvReader.ReuseRecord = true
+	header, err := readCSVHeader(csvReader, "input file")
+	if err != nil {
+		return nil, err
+	}
+
+	column, err := embeddingColumnIndex(header)
+	if err != nil {
+		return nil, err
+	}
+
+	var failures []embeddingFailure
+	var linesCount int
+	for limit <= 0 || linesCount < limit {
+		record, err := csvReader.Read()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, fmt.Errorf("unable to parse file as CSV %w", err)
+		}
+		linesCount++
+
+		if err := validateEmbedding(record[column], layout); err != nil {
+			failures = append(failures, embeddingFailure{Line: linesCount, Err: err})
+			if len(failures) >= maxFailures {
+				break
+			}
+		}
+	}
+
+	fmt.Println("lines count: ", linesCount)
+
+	return failures, nil
+}
+
+// validateEmbedding checks that the embedding holds exactly layout.Dim finite float32 values, split and parsed like
+// the importer does, without building a vector
+func validateEmbedding(strEmbedding string, layout embeddingLayout) error {
+	if layout.Delimiter == defaultVectorDelimiter {
+		strEmbedding, _ = trimTrailingSeparator(strEmbedding)
+	}
+
+	strValues := splitEmbedding(strEmbedding, layout.Delimiter)
+	if len(strValues) != layout.Dim {
+		return fmt.Errorf("vector size not equal embedding values size: %d", len(strValues))
+	}
+
+	for i, strValue := range strValues {
+		value, err := parseFloat32(strValue, i)
+		if err != nil {
+			return err
+		}
+		if math.IsNaN(float64(value)) {
+			return fmt.Errorf("value %s is not finite, position %d", strValue, i)
+		}
+	}
+
+	return nil
+}
+
+type verifyError struct {
+	Line           int
+	Position       int
+	OriginalValue  string
+	ConvertedValue string
+}
+
+// verify parses embeddings of up to limit records (0 for all) and reports values that don't survive a round trip
+// through float32 within tolerance, vector norms are collected into norms unless it is nil
+func verify(f io.Reader, layout embeddingLayout, limit int, norms *normStats, tolerance floatTolerance) ([]verifyError, error) {
+	csvReader := csv.NewReader(f)
+	header, err := readCSVHeader(csvReader, "input file")
+	if err != nil {
+		return nil, err
+	}
+
+	column, err := embeddingColumnIndex(header)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := make([]verifyError, 0)
+
+	var linesCount int
+	vectorBuffer := make([]float32, layout.Dim)
+
+	for {
+		record, err := csvReader.Read()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, fmt.Errorf("unable to parse file as CSV %w", err)
+		}
+
+		input := record[column]
+		if layout.Delimiter == defaultVectorDelimiter {
+			var trimmed bool
+			if input, trimmed = trimTrailingSeparator(input); trimmed {
+				log.Printf("line %d: trailing separator in embedding ignored", linesCount)
+			}
+		}
+		strValues := splitEmbedding(input, layout.Delimiter)
+		if len(strValues) != layout.Dim {
+			return nil, fmt.Errorf("vector size not equal embedding values size: %d, line: %d", len(strValues), linesCount)
+		}
+
+		var valuesChecked int
+		for i, strValue := range strValues {
+			value, err := strconv.ParseFloat(strValue, 32)
+			if err != nil {
+				return nil, fmt.Errorf("error parsing value: %v, line %d, position %d", err, linesCount, i)
+			}
+
+			vectorBuffer[i] = float32(value)
+
+			// the shortest text of the float32, what export writes by default, is canonical and always matches
+			converted := strconv.FormatFloat(value, 'g', -1, 32)
+			// the error was caught above, the float32 parse succeeded so the float64 one does too
+			original, _ := strconv.ParseFloat(strValue, 64)
+			if converted != strValue && !tolerance.Equal(original, value) {
+				resp = append(resp, verifyError{
+					Line:           linesCount,
+					Position:       i,
+					OriginalValue:  strValue,
+					ConvertedValue: converted,
+				})
+			}
+			valuesChecked++
+		}
+
+		if norms != nil {
+			norms.Add(vectorBuffer)
+		}
+
+		linesCount++
+
+		if limit > 0 && linesCount >= limit {
+			break
+		}
+	}
+
+	fmt.Println("lines count: ", linesCount)
+
+	return resp, nil
+}
+
+// precisionLoss compares values parsed as float64 with their stored float32 counterparts and returns
+// the positions where they differ beyond tolerance
+func precisionLoss(strEmbedding, delimiter string, vector []float32, tolerance floatTolerance) []verifyError {
+	strValues := splitEmbedding(strEmbedding, delimiter)
+
+	var resp []verifyError
+	for i, strValue := range strValues {
+		if i >= len(vector) {
+			break
+		}
+
+		original, err := strconv.ParseFloat(strValue, 64)
+		if err != nil || original == 0 {
+			continue
+		}
+
+		stored := float64(vector[i])
+		if !tolerance.Equal(original, stored) {
+			resp = append(resp, verifyError{
+				Position:       i,
+				OriginalValue:  strValue,
+				ConvertedValue: strconv.FormatFloat(stored, 'g', -1, 32),
+			})
+		}
+	}
+
+	return resp
+}
+
+// floatTolerance decides when a value as written and the float32 stored for it count as equal, for verify and the
+// precision loss warnings of import. Exact requires the same bits, otherwise the error relative to the written value
+// must not exceed Epsilon, 2^-24 for any float32 rounding. It is a flag.Value: exact, the default, or a
+// non-negative number.
+type floatTolerance struct {
+	Exact   bool
+	Epsilon float64
+}
+
+// Equal compares a written value with its stored counterpart
+func (t floatTolerance) Equal(original, stored float64) bool {
+	if t.Exact {
+		return math.Float64bits(original) == math.Float64bits(stored)
+	}
+	if original == stored {
+		return true
+	}
+
+	return math.Abs(original-stored) <= t.Epsilon*math.Abs(original)
+}
+
+func (t *floatTolerance) String() string {
+	if t.Exact {
+		return "exact"
+	}
+	return strconv.FormatFloat(t.Epsilon, 'g', -1, 64)
+}
+
+func (t *floatTolerance) Set(value string) error {
+	if value == "exact" {
+		*t = floatTolerance{Exact: true}
+		return nil
+	}
+
+	epsilon, err := strconv.ParseFloat(value, 64)
+	if err != nil || epsilon < 0 || math.IsNaN(epsilon) {
+		return fmt.Errorf("expected exact or a non-negative number, got %q", value)
+	}
+	*t = floatTolerance{Epsilon: epsilon}
+
+	return nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestFloatToleranceEqual(t *testing.T) {
+	tests := []struct {
+		tolerance string
+		value     string
+		want      bool
+	}{
+		{tolerance: "exact", value: "0.25", want: true},
+		{tolerance: "exact", value: "0.1", want: false},
+		{tolerance: "exact", value: "0.10000000149011612", want: true},
+		{tolerance: "0", value: "0.1", want: false},
+		{tolerance: "5.96e-08", value: "0.1", want: true},
+		{tolerance: "5.96e-08", value: "0.123456789", want: true},
+		{tolerance: "1e-9", value: "0.123456789", want: false},
+	}
+
+	for _, tt := range tests {
+		var tolerance floatTolerance
+		if err := tolerance.Set(tt.tolerance); err != nil {
+			t.Fatal(err)
+		}
+
+		original, err := strconv.ParseFloat(tt.value, 64)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stored, err := strconv.ParseFloat(tt.value, 32)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := tolerance.Equal(original, stored); got != tt.want {
+			t.Errorf("-float-epsilon %s: Equal for %s = %v, want %v", tt.tolerance, tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestFloatToleranceSet(t *testing.T) {
+	for _, value := range []string{"-1", "NaN", "close"} {
+		var tolerance floatTolerance
+		if err := tolerance.Set(value); err == nil {
+			t.Errorf("Set(%q) accepted", value)
+		}
+	}
+}
+
+// with exact comparison, the default -float-epsilon, values rounded when stored as float32 are reported unless
+// written as the shortest float32 text
+func TestVerifyReportsRounding(t *testing.T) {
+	input := "embedding,url,content,type\n" +
+		"\"[0.5, 0.1, 0.123456789]\",https://example.com/a,first,title\n"
+
+	mismatches, err := verify(strings.NewReader(input), embeddingLayout{Dim: 3, Delimiter: defaultVectorDelimiter}, 0, nil, floatTolerance{Exact: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mismatches) != 1 || mismatches[0].Position != 2 || mismatches[0].ConvertedValue != "0.12345679" {
+		t.Errorf("mismatches = %+v, want position 2 only", mismatches)
+	}
+}
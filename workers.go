@@ -0,0 +1,215 @@
+package main
+
+import (
+	"errors"
+	"runtime"
+	"sync"
+)
+
+// recordTask is a record handed to a worker with its position in the input
+type recordTask struct {
+	Record Record
+	Read   int64 // records read up to and including this one
+}
+
+// workerPool runs process for submitted records on workers goroutines, with a single worker records are
+// processed inline by Submit. After the first error no more records are processed.
+type workerPool struct {
+	process func(rec Record, read int64) error
+	tasks   chan recordTask
+	wg      sync.WaitGroup
+	mu      sync.Mutex
+	err     error
+}
+
+func newWorkerPool(workers int, process func(rec Record, read int64) error) *workerPool {
+	p := &workerPool{process: process}
+	if workers > 1 {
+		p.tasks = make(chan recordTask)
+		for i := 0; i < workers; i++ {
+			p.wg.Add(1)
+			go p.work()
+		}
+	}
+
+	return p
+}
+
+func (p *workerPool) work() {
+	defer p.wg.Done()
+
+	for t := range p.tasks {
+		// drain the queue without processing once the pool failed
+		if p.Err() != nil {
+			continue
+		}
+		p.run(t)
+	}
+}
+
+func (p *workerPool) run(t recordTask) {
+	if err := p.process(t.Record, t.Read); err != nil {
+		p.mu.Lock()
+		if p.err == nil {
+			p.err = err
+		}
+		p.mu.Unlock()
+	}
+}
+
+// Submit processes the record, on a worker when there are several of them
+func (p *workerPool) Submit(rec Record, read int64) {
+	if p.tasks == nil {
+		p.run(recordTask{Record: rec, Read: read})
+		return
+	}
+
+	p.tasks <- recordTask{Record: rec, Read: read}
+}
+
+// Err returns the first error of process
+func (p *workerPool) Err() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.err
+}
+
+// Wait lets the workers finish the submitted records and returns the first error, it can be called more than once
+func (p *workerPool) Wait() error {
+	if p.tasks != nil {
+		close(p.tasks)
+		p.wg.Wait()
+		p.tasks = nil
+	}
+
+	return p.Err()
+}
+
+// inflightRecords tracks records being processed, so a resume position never passes a record that isn't finished
+type inflightRecords struct {
+	mu      sync.Mutex
+	records map[int64]struct{}
+}
+
+func newInflightRecords() *inflightRecords {
+	return &inflightRecords{records: make(map[int64]struct{})}
+}
+
+func (f *inflightRecords) Start(read int64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.records[read] = struct{}{}
+}
+
+func (f *inflightRecords) Done(read int64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	delete(f.records, read)
+}
+
+// Handled returns how many records from the start of the input are finished, given that read were read so far
+func (f *inflightRecords) Handled(read int64) int64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	handled := read
+	for r := range f.records {
+		if r-1 < handled {
+			handled = r - 1
+		}
+	}
+
+	return handled
+}
+
+// autoWorkers picks a worker count from the available CPUs, capped by the connection limits of the databases
+// (0 is unlimited) minus headroom for the lock and batch connections
+func autoWorkers(maxOpenConns ...int) int {
+	const connHeadroom = 2
+
+	workers := runtime.GOMAXPROCS(0)
+	for _, limit := range maxOpenConns {
+		if limit > 0 && limit-connHeadroom < workers {
+			workers = limit - connHeadroom
+		}
+	}
+
+	if workers < 1 {
+		workers = 1
+	}
+
+	return workers
+}
+
+var errOrderAborted = errors.New("ordered write aborted after an earlier record failed")
+
+// orderGate lets records pass one at a time in input order: Wait returns once every earlier record was released.
+// Every record read must be released, whether it waited or not. Methods are safe on a nil gate, which never blocks.
+type orderGate struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	next     int64 // lowest read that isn't released
+	released map[int64]struct{}
+	aborted  bool
+}
+
+func newOrderGate() *orderGate {
+	g := &orderGate{next: 1, released: make(map[int64]struct{})}
+	g.cond = sync.NewCond(&g.mu)
+
+	return g
+}
+
+// Wait blocks until the records before read are released, it fails once the gate is aborted
+func (g *orderGate) Wait(read int64) error {
+	if g == nil {
+		return nil
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for g.next < read && !g.aborted {
+		g.cond.Wait()
+	}
+	if g.aborted {
+		return errOrderAborted
+	}
+
+	return nil
+}
+
+func (g *orderGate) Release(read int64) {
+	if g == nil {
+		return
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.released[read] = struct{}{}
+	for {
+		if _, ok := g.released[g.next]; !ok {
+			break
+		}
+		delete(g.released, g.next)
+		g.next++
+	}
+	g.cond.Broadcast()
+}
+
+// Abort wakes every waiting record with an error, records the failed pool drains are never released
+func (g *orderGate) Abort() {
+	if g == nil {
+		return
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.aborted = true
+	g.cond.Broadcast()
+}